@@ -0,0 +1,263 @@
+/*
+Fighter aliases; alternate, renamed or mangled fighter names mapped to the name
+the fighter is actually stored under. Shared by the scraper & the IRC bot.
+*/
+package aliases
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/strider-/dreamer/storage"
+	"strings"
+	"time"
+)
+
+const (
+	CREATE_ALIASES_SQL string = `CREATE TABLE IF NOT EXISTS fighter_aliases (
+		alias varchar(255) PRIMARY KEY,
+		canonical varchar(255) NOT NULL,
+		created timestamp NOT NULL)`
+
+	// matches between fighters $1 & $2, whichever side they were on
+	BETWEEN_SQL string = `SELECT match_id FROM matches WHERE (red_id = $1 AND blue_id = $2) OR (red_id = $2 AND blue_id = $1)`
+)
+
+// way to go salty, unescaped brackets in html HOW CAN YOU GO WRONG
+var seeds = map[string]string{
+	" ( 0)/2": "<> ( 0)<>/2",
+}
+
+// A loaded snapshot of the alias table
+type Table struct {
+	names map[string]string
+}
+
+type Alias struct {
+	Alias, Canonical string
+	Created          time.Time
+}
+
+// Creates the alias table if need be, seeding a new one with the fixes we've always needed; after
+// that the seeds are the admins' to remove. Stores without SQL (the in-memory one) have nowhere to
+// keep aliases, so there's nothing to do.
+func CreateTable(db storage.Store) error {
+	if _, ok := db.(storage.SQLStore); !ok {
+		return nil
+	}
+	if tableExists(db, "fighter_aliases") {
+		return nil
+	}
+	return storage.WithTrans(db, func(tx *sql.Tx) error {
+		if _, err := tx.Exec(CREATE_ALIASES_SQL); err != nil {
+			return err
+		}
+		for alias, canonical := range seeds {
+			if err := upsert(tx, alias, canonical); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Reads every alias into memory; an empty table for stores without SQL
+func Load(db storage.Store) (*Table, error) {
+	if _, ok := db.(storage.SQLStore); !ok {
+		return &Table{names: make(map[string]string)}, nil
+	}
+	list, err := List(db)
+	if err != nil {
+		return nil, err
+	}
+	t := &Table{names: make(map[string]string, len(list))}
+	for _, a := range list {
+		t.names[a.Alias] = a.Canonical
+	}
+	return t, nil
+}
+
+// Returns the canonical name for name, or name itself if it isn't an alias. Aliases of aliases
+// (which Add turns away, but older tables may have) are followed to the end, stopping at a loop.
+// Safe to call on a nil table.
+func (t *Table) Resolve(name string) string {
+	if t == nil {
+		return name
+	}
+	seen := make(map[string]bool)
+	for {
+		canonical, ok := t.names[name]
+		if !ok || seen[canonical] {
+			return name
+		}
+		seen[name] = true
+		name = canonical
+	}
+}
+
+func List(db storage.Store) (list []Alias, err error) {
+	err = storage.WithTrans(db, func(tx *sql.Tx) error {
+		rows, e := tx.Query(`SELECT alias, canonical, created FROM fighter_aliases ORDER BY canonical, alias`)
+		if e != nil {
+			return e
+		}
+		defer rows.Close()
+		for rows.Next() {
+			a := Alias{}
+			if e := rows.Scan(&a.Alias, &a.Canonical, &a.Created); e != nil {
+				return e
+			}
+			list = append(list, a)
+		}
+		return rows.Err()
+	})
+	return
+}
+
+// Points alias at canonical, replacing whatever it pointed at before. Canonical can't be an alias
+// itself, & alias can't have aliases of its own, so every alias is a single hop from a fighter.
+func Add(db storage.Store, alias, canonical string) error {
+	alias, canonical = strings.TrimSpace(alias), strings.TrimSpace(canonical)
+	if alias == "" || canonical == "" {
+		return errors.New("alias and canonical name are both required")
+	}
+	if alias == canonical {
+		return errors.New("a fighter can't be an alias of itself")
+	}
+	return storage.WithTrans(db, func(tx *sql.Tx) error {
+		var target string
+		switch err := tx.QueryRow(`SELECT canonical FROM fighter_aliases WHERE alias = $1`, canonical).Scan(&target); err {
+		case nil:
+			return fmt.Errorf("'%s' is itself an alias of '%s', use that instead", canonical, target)
+		case sql.ErrNoRows:
+		default:
+			return err
+		}
+		var count int
+		if err := tx.QueryRow(`SELECT COUNT(*) FROM fighter_aliases WHERE canonical = $1`, alias).Scan(&count); err != nil {
+			return err
+		}
+		if count > 0 {
+			return fmt.Errorf("'%s' has aliases of its own, merge it into '%s' instead", alias, canonical)
+		}
+		return upsert(tx, alias, canonical)
+	})
+}
+
+func Remove(db storage.Store, alias string) error {
+	return storage.WithTrans(db, func(tx *sql.Tx) error {
+		res, err := tx.Exec(`DELETE FROM fighter_aliases WHERE alias = $1`, strings.TrimSpace(alias))
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return fmt.Errorf("'%s' isn't an alias", alias)
+		}
+		return nil
+	})
+}
+
+// Folds the fighter named from into the fighter named into: their matches, compendium history &
+// rating snapshots are moved over, bet totals combined, from's record deleted & from's name left
+// behind as an alias. Where both have a current attribute, status or snapshot, into's is kept.
+// Matches the two fought each other would be into against itself, so they're deleted along with
+// their details, outcomes & snapshots; dropped is how many went.
+// Ratings aren't recombined: into keeps its own & from's is lost, until the scraper's run with
+// -reset-elo -salt-the-earth to rebuild them.
+func Merge(repo storage.SQLStore, from, into string) (dropped int, err error) {
+	src, err := repo.GetFighter(from)
+	if err != nil || src == nil || src.Id == 0 {
+		return 0, fmt.Errorf("no fighter named '%s'", from)
+	}
+	dst, err := repo.GetFighter(into)
+	if err != nil || dst == nil || dst.Id == 0 {
+		return 0, fmt.Errorf("no fighter named '%s'", into)
+	}
+	if src.Id == dst.Id {
+		return 0, errors.New("can't merge a fighter into itself")
+	}
+
+	// the scraper's tables, which the bot alone mightn't have made
+	var rematches []string
+	for _, table := range []string{"rating_snapshots", "match_details", "match_outcomes"} {
+		if tableExists(repo, table) {
+			rematches = append(rematches, `DELETE FROM `+table+` WHERE match_id IN (`+BETWEEN_SQL+`)`)
+		}
+	}
+
+	moves := []string{
+		`UPDATE matches SET red_id = $2 WHERE red_id = $1`,
+		`UPDATE matches SET blue_id = $2 WHERE blue_id = $1`,
+		`DELETE FROM fighter_attributes WHERE fighter_id = $1 AND name IN (SELECT name FROM fighter_attributes WHERE fighter_id = $2)`,
+		`UPDATE fighter_attributes SET fighter_id = $2 WHERE fighter_id = $1`,
+		`UPDATE fighter_attribute_history SET fighter_id = $2 WHERE fighter_id = $1`,
+	}
+	// only the scraper makes rating snapshots, there mightn't be any yet
+	if tableExists(repo, "rating_snapshots") {
+		moves = append(moves,
+			`DELETE FROM rating_snapshots WHERE fighter_id = $1 AND match_id IN (SELECT match_id FROM rating_snapshots WHERE fighter_id = $2)`,
+			`UPDATE rating_snapshots SET fighter_id = $2 WHERE fighter_id = $1`)
+	}
+
+	err = storage.WithTrans(repo, func(tx *sql.Tx) error {
+		for _, q := range rematches {
+			if _, err := tx.Exec(q, src.Id, dst.Id); err != nil {
+				return err
+			}
+		}
+		res, err := tx.Exec(`DELETE FROM matches WHERE match_id IN (`+BETWEEN_SQL+`)`, src.Id, dst.Id)
+		if err != nil {
+			return err
+		}
+		n, _ := res.RowsAffected()
+		dropped = int(n)
+
+		for _, q := range moves {
+			if _, err := tx.Exec(q, src.Id, dst.Id); err != nil {
+				return err
+			}
+		}
+		dst.TotalBets += src.TotalBets
+		if err := repo.UpdateFighterInTrans(dst, tx); err != nil {
+			return err
+		}
+		for _, table := range []string{"fighter_attribute_checks", "fighter_status"} {
+			if _, err := tx.Exec(`DELETE FROM `+table+` WHERE fighter_id = $1`, src.Id); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.Exec(`DELETE FROM fighters WHERE id = $1`, src.Id); err != nil {
+			return err
+		}
+		// anything that pointed at the old name follows it
+		if _, err := tx.Exec(`UPDATE fighter_aliases SET canonical = $2 WHERE canonical = $1`, src.Name, dst.Name); err != nil {
+			return err
+		}
+		return upsert(tx, src.Name, dst.Name)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return dropped, nil
+}
+
+// Whether a table's there, by trying it in a transaction of its own (a failed statement spoils
+// the rest of a postgres transaction)
+func tableExists(db storage.Store, table string) bool {
+	return storage.WithTrans(db, func(tx *sql.Tx) error {
+		_, err := tx.Exec(`SELECT 1 FROM ` + table + ` WHERE 1 = 0`)
+		return err
+	}) == nil
+}
+
+func upsert(tx *sql.Tx, alias, canonical string) error {
+	res, err := tx.Exec(`UPDATE fighter_aliases SET canonical = $2 WHERE alias = $1`, alias, canonical)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		return nil
+	}
+	_, err = tx.Exec(`INSERT INTO fighter_aliases (alias, canonical, created) VALUES ($1, $2, $3)`, alias, canonical, time.Now())
+	return err
+}
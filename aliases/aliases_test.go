@@ -0,0 +1,208 @@
+package aliases
+
+import (
+	"database/sql"
+	"github.com/strider-/dreamer/compendium"
+	"github.com/strider-/dreamer/roster"
+	"github.com/strider-/dreamer/storage"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"spicerack"
+	"testing"
+	"time"
+)
+
+// A SQLite store with every table Merge touches, including a cut-down match_outcomes standing in
+// for the scraper's
+func openStore(t *testing.T) *storage.SQLite {
+	dir, err := ioutil.TempDir("", "aliases")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	db, err := storage.OpenSQLite(filepath.Join(dir, "salty.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(db.Close)
+	for _, create := range []func(storage.Store) error{CreateTable, compendium.CreateTables, roster.CreateTable} {
+		if err := create(db); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = storage.WithTrans(db, func(tx *sql.Tx) error {
+		_, err := tx.Exec(`CREATE TABLE match_outcomes (match_id integer PRIMARY KEY, outcome varchar(16) NOT NULL)`)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestResolve(t *testing.T) {
+	table := &Table{names: map[string]string{
+		"Ryu (old)": "Ryu",
+		"Ken2":      "Ken (old)", // an alias of an alias, from before Add turned those away
+		"Ken (old)": "Ken",
+		"loop a":    "loop b",
+		"loop b":    "loop a",
+	}}
+
+	if got := table.Resolve("Ryu (old)"); got != "Ryu" {
+		t.Errorf("an alias resolved to %q", got)
+	}
+	if got := table.Resolve("Ryu"); got != "Ryu" {
+		t.Errorf("a canonical name resolved to %q", got)
+	}
+	if got := table.Resolve("Ken2"); got != "Ken" {
+		t.Errorf("a chain of aliases resolved to %q, want the end of it", got)
+	}
+	if got := table.Resolve("loop a"); got != "loop b" && got != "loop a" {
+		t.Errorf("a loop resolved to %q", got)
+	}
+	if got := (*Table)(nil).Resolve("Ryu (old)"); got != "Ryu (old)" {
+		t.Errorf("a nil table resolved a name to %q", got)
+	}
+}
+
+func TestAdd(t *testing.T) {
+	db := openStore(t)
+
+	if err := Add(db, " Ryu (old) ", "Ryu"); err != nil {
+		t.Fatal(err)
+	}
+	if err := Add(db, "Ryu", "Ryu"); err == nil {
+		t.Error("a fighter was made an alias of itself")
+	}
+	if err := Add(db, "Ryu2", "Ryu (old)"); err == nil {
+		t.Error("an alias was pointed at another alias")
+	}
+	if err := Add(db, "Ryu", "Ken"); err == nil {
+		t.Error("a fighter with aliases was made an alias itself")
+	}
+
+	table, err := Load(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := table.Resolve("Ryu (old)"); got != "Ryu" {
+		t.Errorf("added alias resolves to %q", got)
+	}
+	for alias, canonical := range seeds {
+		if got := table.Resolve(alias); got != canonical {
+			t.Errorf("seeded alias %q resolves to %q, want %q", alias, got, canonical)
+		}
+	}
+
+	if err := Remove(db, "Ryu (old)"); err != nil {
+		t.Fatal(err)
+	}
+	if err := Remove(db, "Ryu (old)"); err == nil {
+		t.Error("removing a missing alias didn't complain")
+	}
+}
+
+func TestMerge(t *testing.T) {
+	db := openStore(t)
+
+	fighters := map[string]*spicerack.Fighter{}
+	for _, f := range []*spicerack.Fighter{
+		{Name: "Ryu", Elo: 400, TotalBets: 1000},
+		{Name: "Ryu (old)", Elo: 250, TotalBets: 500},
+		{Name: "Ken", Elo: 300},
+	} {
+		if err := db.UpdateFighter(f); err != nil {
+			t.Fatal(err)
+		}
+		fighters[f.Name] = f
+	}
+	ryu, old, ken := fighters["Ryu"], fighters["Ryu (old)"], fighters["Ken"]
+
+	now := time.Now()
+	for _, m := range []*spicerack.Match{
+		{MatchId: 100000, RedId: old.Id, BlueId: ken.Id, Winner: int(spicerack.WINNER_RED)},
+		{MatchId: 100001, RedId: ken.Id, BlueId: ryu.Id, Winner: int(spicerack.WINNER_BLUE)},
+		// Ryu against himself once merged, from both sides
+		{MatchId: 100002, RedId: old.Id, BlueId: ryu.Id, Winner: int(spicerack.WINNER_BLUE)},
+		{MatchId: 100003, RedId: ryu.Id, BlueId: old.Id, Winner: int(spicerack.WINNER_BLUE)},
+	} {
+		m.Created, m.Updated = now, now
+		if err := db.InsertMatch(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := storage.WithTrans(db, func(tx *sql.Tx) error {
+		_, err := tx.Exec(`INSERT INTO match_outcomes (match_id, outcome) VALUES (100001, 'blue'), (100002, 'blue')`)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Add(db, "Ryu old", "Ryu (old)"); err != nil {
+		t.Fatal(err)
+	}
+
+	dropped, err := Merge(db, "Ryu (old)", "Ryu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dropped != 2 {
+		t.Errorf("dropped %d matches, want the 2 between them", dropped)
+	}
+
+	if f, _ := db.GetFighter("Ryu (old)"); f.Id != 0 {
+		t.Error("the merged fighter is still there")
+	}
+	merged, _ := db.GetFighter("Ryu")
+	if merged.TotalBets != 1500 || merged.Elo != 400 {
+		t.Errorf("merged fighter has %d bets & a rating of %d, want 1500 & their own 400", merged.TotalBets, merged.Elo)
+	}
+
+	h := db.GetHistory(merged)
+	if len(h.Wins) != 2 || len(h.Losses) != 0 {
+		t.Errorf("merged history is %d wins & %d losses, want the 2 wins over Ken", len(h.Wins), len(h.Losses))
+	}
+	for _, w := range h.Wins {
+		if w.Opponent != "Ken" {
+			t.Errorf("merged fighter has a win over %q", w.Opponent)
+		}
+	}
+
+	var outcomes int
+	storage.WithTrans(db, func(tx *sql.Tx) error {
+		return tx.QueryRow(`SELECT COUNT(*) FROM match_outcomes`).Scan(&outcomes)
+	})
+	if outcomes != 1 {
+		t.Errorf("%d match outcomes left, want the dropped match's gone", outcomes)
+	}
+
+	table, err := Load(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"Ryu (old)", "Ryu old"} {
+		if got := table.Resolve(name); got != "Ryu" {
+			t.Errorf("%q resolves to %q after the merge, want Ryu", name, got)
+		}
+	}
+}
+
+func TestMergeProblems(t *testing.T) {
+	db := openStore(t)
+	if err := db.UpdateFighter(&spicerack.Fighter{Name: "Ryu"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Merge(db, "Ryu", "Ryu"); err == nil {
+		t.Error("merged a fighter into itself")
+	}
+	if _, err := Merge(db, "Akuma", "Ryu"); err == nil || err.Error() != "no fighter named 'Akuma'" {
+		t.Errorf("merging an unknown fighter gave %v", err)
+	}
+	if _, err := Merge(db, "Ryu", "Akuma"); err == nil || err.Error() != "no fighter named 'Akuma'" {
+		t.Errorf("merging into an unknown fighter gave %v", err)
+	}
+}
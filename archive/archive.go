@@ -0,0 +1,172 @@
+/*
+Pages fetched from salty kept in a directory or tarball, either being recorded during a live
+scrape or replayed in place of the network. Used by the scraper's -record, -replay & -self-test.
+*/
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Archive struct {
+	path   string
+	replay bool
+	mu     sync.Mutex
+	pages  map[string][]byte
+	file   *os.File
+	gz     *gzip.Writer
+	tw     *tar.Writer
+}
+
+// Starts recording pages to path; a path ending in .tar.gz or .tgz is written as a tarball,
+// anything else as a directory of html files
+func Record(path string) (*Archive, error) {
+	a := &Archive{path: path}
+	if !isTarball(path) {
+		return a, os.MkdirAll(path, 0755)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	a.file = f
+	a.gz = gzip.NewWriter(f)
+	a.tw = tar.NewWriter(a.gz)
+	return a, nil
+}
+
+// Opens an archive for replay. Tarballs are read into memory up front.
+func Replay(path string) (*Archive, error) {
+	a := &Archive{path: path, replay: true}
+	if !isTarball(path) {
+		_, err := os.Stat(path)
+		return a, err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+
+	a.pages = make(map[string][]byte)
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if a.pages[hdr.Name], err = ioutil.ReadAll(tr); err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+// Whether pages come from the archive rather than being recorded to it
+func (a *Archive) Replaying() bool {
+	return a.replay
+}
+
+// Stores a fetched page under a name derived from its url
+func (a *Archive) Save(pageUrl string, page []byte) error {
+	name := Name(pageUrl)
+	if a.tw == nil {
+		return ioutil.WriteFile(filepath.Join(a.path, name), page, 0644)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(page)), ModTime: time.Now()}
+	if err := a.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := io.Copy(a.tw, bytes.NewReader(page))
+	return err
+}
+
+// Returns an archived page, or an error if the page was never recorded
+func (a *Archive) Load(pageUrl string) ([]byte, error) {
+	return a.LoadName(Name(pageUrl))
+}
+
+// Returns an archived page by its name in the archive, as listed by Names
+func (a *Archive) LoadName(name string) ([]byte, error) {
+	if a.pages == nil {
+		return ioutil.ReadFile(filepath.Join(a.path, name))
+	}
+
+	if page, ok := a.pages[name]; ok {
+		return page, nil
+	}
+	return nil, fmt.Errorf("%s isn't in archive %s", name, a.path)
+}
+
+// Names of every archived page, sorted
+func (a *Archive) Names() ([]string, error) {
+	var names []string
+	if a.pages != nil {
+		for name := range a.pages {
+			names = append(names, name)
+		}
+	} else {
+		files, err := ioutil.ReadDir(a.path)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			if !f.IsDir() && strings.HasSuffix(f.Name(), ".html") {
+				names = append(names, f.Name())
+			}
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Finishes writing a tarball; a no-op for directories & replays
+func (a *Archive) Close() error {
+	if a.tw == nil {
+		return nil
+	}
+	a.tw.Close()
+	a.gz.Close()
+	return a.file.Close()
+}
+
+// Turns a salty url into a flat file name, e.g. stats?tournament_id=101&page=2 -> stats_tournament_id_101_page_2.html
+func Name(pageUrl string) string {
+	if u, err := url.Parse(pageUrl); err == nil {
+		pageUrl = strings.TrimPrefix(u.RequestURI(), "/")
+	}
+	name := strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '-' || r == '.' {
+			return r
+		}
+		return '_'
+	}, pageUrl)
+	return name + ".html"
+}
+
+func isTarball(path string) bool {
+	return strings.HasSuffix(path, ".tar.gz") || strings.HasSuffix(path, ".tgz")
+}
@@ -0,0 +1,90 @@
+package archive
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestName(t *testing.T) {
+	tests := []struct{ url, want string }{
+		{"http://www.saltybet.com/stats?tournament_id=101&page=2", "stats_tournament_id_101_page_2.html"},
+		{"http://www.saltybet.com/stats?tournamentstats=1&page=1", "stats_tournamentstats_1_page_1.html"},
+		{"http://www.saltybet.com/compendium?search=", "compendium_search_.html"},
+		{"http://www.saltybet.com/compendium?tier=1&character=4321", "compendium_tier_1_character_4321.html"},
+		// the host doesn't matter, so a recording replays against any mirror
+		{"https://saltybet.example/stats?tournament_id=101&page=2", "stats_tournament_id_101_page_2.html"},
+		{"/stats?tournament_id=7", "stats_tournament_id_7.html"},
+		{"a-b.c/d", "a-b.c_d.html"},
+	}
+	for _, tt := range tests {
+		if got := Name(tt.url); got != tt.want {
+			t.Errorf("Name(%q) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
+
+// Pages recorded to either kind of archive come back out of a replay of it
+func TestRecordReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	pages := map[string]string{
+		"http://www.saltybet.com/stats?tournament_id=101&page=1": "<html>page one</html>",
+		"http://www.saltybet.com/stats?tournament_id=101&page=2": "<html>page two</html>",
+		"http://www.saltybet.com/compendium?search=":             "<html>roster</html>",
+	}
+	for _, path := range []string{filepath.Join(dir, "pages"), filepath.Join(dir, "pages.tar.gz"), filepath.Join(dir, "pages.tgz")} {
+		rec, err := Record(path)
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		if rec.Replaying() {
+			t.Errorf("%s: a recording says it's replaying", path)
+		}
+		for url, page := range pages {
+			if err := rec.Save(url, []byte(page)); err != nil {
+				t.Fatalf("%s: %v", path, err)
+			}
+		}
+		if err := rec.Close(); err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+
+		rep, err := Replay(path)
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		if !rep.Replaying() {
+			t.Errorf("%s: a replay says it isn't replaying", path)
+		}
+		for url, want := range pages {
+			if got, err := rep.Load(url); err != nil || string(got) != want {
+				t.Errorf("%s: Load(%q) = %q, %v, want %q", path, url, got, err, want)
+			}
+		}
+		if _, err := rep.Load("http://www.saltybet.com/stats?tournament_id=102&page=1"); err == nil {
+			t.Errorf("%s: loaded a page that was never recorded", path)
+		}
+
+		names, err := rep.Names()
+		want := []string{"compendium_search_.html", "stats_tournament_id_101_page_1.html", "stats_tournament_id_101_page_2.html"}
+		if err != nil || len(names) != len(want) {
+			t.Fatalf("%s: Names() = %v, %v, want %v", path, names, err, want)
+		}
+		for i := range want {
+			if names[i] != want[i] {
+				t.Errorf("%s: Names()[%d] = %q, want %q", path, i, names[i], want[i])
+			}
+		}
+		rep.Close()
+	}
+
+	if _, err := Replay(filepath.Join(dir, "missing")); err == nil {
+		t.Error("replayed a directory that isn't there")
+	}
+}
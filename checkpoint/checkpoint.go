@@ -0,0 +1,77 @@
+/*
+Where a previous scrape got to, one per scrape mode, so a crashed run can pick up where it left off
+rather than starting over.
+*/
+package checkpoint
+
+import (
+	"database/sql"
+	"time"
+)
+
+const (
+	CREATE_CHECKPOINTS_SQL string = `CREATE TABLE IF NOT EXISTS scrape_checkpoints (
+		mode varchar(32) PRIMARY KEY,
+		tournament_id integer NOT NULL,
+		page integer NOT NULL,
+		has_next boolean NOT NULL,
+		last_match_id integer NOT NULL,
+		updated timestamp NOT NULL)`
+)
+
+type Checkpoint struct {
+	Mode         string
+	TournamentId int
+	Page         int
+	HasNext      bool
+	LastMatchId  int
+}
+
+// Returns the saved checkpoint for a scrape mode, or nil if the last run finished
+func Load(tx *sql.Tx, mode string) (*Checkpoint, error) {
+	cp := &Checkpoint{Mode: mode}
+	row := tx.QueryRow(`SELECT tournament_id, page, has_next, last_match_id
+		FROM scrape_checkpoints WHERE mode = $1`, mode)
+	switch err := row.Scan(&cp.TournamentId, &cp.Page, &cp.HasNext, &cp.LastMatchId); err {
+	case nil:
+		return cp, nil
+	case sql.ErrNoRows:
+		return nil, nil
+	default:
+		return nil, err
+	}
+}
+
+func Save(tx *sql.Tx, cp *Checkpoint) error {
+	if err := Clear(tx, cp.Mode); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`INSERT INTO scrape_checkpoints (mode, tournament_id, page, has_next, last_match_id, updated)
+		VALUES ($1, $2, $3, $4, $5, $6)`, cp.Mode, cp.TournamentId, cp.Page, cp.HasNext, cp.LastMatchId, time.Now())
+	return err
+}
+
+func Clear(tx *sql.Tx, mode string) error {
+	_, err := tx.Exec(`DELETE FROM scrape_checkpoints WHERE mode = $1`, mode)
+	return err
+}
+
+// Trims the tournament list (oldest first) down to what's left after a checkpoint, returning the
+// page to start the first remaining tournament at. A tournament stopped partway through is picked
+// back up even once it's no longer among the recent ones, so none of its pages are skipped over.
+func Resume(tourneys []int, cp *Checkpoint) ([]int, int) {
+	if cp == nil {
+		return tourneys, 1
+	}
+
+	var rest []int
+	for _, id := range tourneys {
+		if id > cp.TournamentId {
+			rest = append(rest, id)
+		}
+	}
+	if cp.HasNext {
+		return append([]int{cp.TournamentId}, rest...), cp.Page + 1
+	}
+	return rest, 1
+}
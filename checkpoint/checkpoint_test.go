@@ -0,0 +1,110 @@
+package checkpoint
+
+import (
+	"database/sql"
+	"github.com/strider-/dreamer/storage"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResume(t *testing.T) {
+	recent := []int{101, 102, 103, 104}
+	tests := []struct {
+		name  string
+		cp    *Checkpoint
+		want  []int
+		start int
+	}{
+		{"no checkpoint", nil, recent, 1},
+		{"finished a tournament", &Checkpoint{TournamentId: 102, Page: 4}, []int{103, 104}, 1},
+		{"partway through one", &Checkpoint{TournamentId: 102, Page: 4, HasNext: true}, []int{102, 103, 104}, 5},
+		{"finished the last one", &Checkpoint{TournamentId: 104, Page: 2}, nil, 1},
+		// dropped out of the recent ones since, but still has pages left
+		{"partway through an old one", &Checkpoint{TournamentId: 99, Page: 1, HasNext: true}, []int{99, 101, 102, 103, 104}, 2},
+		{"finished an old one", &Checkpoint{TournamentId: 99, Page: 1}, recent, 1},
+	}
+	for _, tt := range tests {
+		got, start := Resume(recent, tt.cp)
+		if start != tt.start || len(got) != len(tt.want) {
+			t.Errorf("%s: resumed at %v page %d, want %v page %d", tt.name, got, start, tt.want, tt.start)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s: resumed at %v, want %v", tt.name, got, tt.want)
+				break
+			}
+		}
+	}
+	if len(recent) != 4 || recent[0] != 101 {
+		t.Errorf("resuming changed the tournament list to %v", recent)
+	}
+}
+
+// Each mode keeps a checkpoint of its own, replaced on every save
+func TestStored(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	db, err := storage.OpenSQLite(filepath.Join(dir, "salty.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	load := func(mode string) (cp *Checkpoint) {
+		t.Helper()
+		err := storage.WithTrans(db, func(tx *sql.Tx) (e error) {
+			cp, e = Load(tx, mode)
+			return
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	save := func(cp *Checkpoint) {
+		t.Helper()
+		if err := storage.WithTrans(db, func(tx *sql.Tx) error { return Save(tx, cp) }); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = storage.WithTrans(db, func(tx *sql.Tx) error {
+		_, err := tx.Exec(CREATE_CHECKPOINTS_SQL)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cp := load("recent"); cp != nil {
+		t.Fatalf("a new table has a checkpoint: %+v", *cp)
+	}
+
+	save(&Checkpoint{Mode: "recent", TournamentId: 101, Page: 1, HasNext: true, LastMatchId: 60000})
+	save(&Checkpoint{Mode: "recent", TournamentId: 101, Page: 2, LastMatchId: 60020})
+	save(&Checkpoint{Mode: "salt-the-earth", TournamentId: 5, Page: 3, HasNext: true, LastMatchId: 52000})
+
+	want := Checkpoint{Mode: "recent", TournamentId: 101, Page: 2, LastMatchId: 60020}
+	if cp := load("recent"); cp == nil || *cp != want {
+		t.Errorf("loaded %v, want the latest save %+v", cp, want)
+	}
+	if cp := load("salt-the-earth"); cp == nil || cp.TournamentId != 5 || !cp.HasNext {
+		t.Errorf("the other mode's checkpoint loaded as %v", cp)
+	}
+
+	err = storage.WithTrans(db, func(tx *sql.Tx) error { return Clear(tx, "recent") })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cp := load("recent"); cp != nil {
+		t.Errorf("a cleared checkpoint loaded as %+v", *cp)
+	}
+	if load("salt-the-earth") == nil {
+		t.Error("clearing one mode cleared the other")
+	}
+}
@@ -0,0 +1,264 @@
+/*
+The IRC bot's commands. Each one is declared once, with its name, aliases, how its arguments
+are split, who can use it, where & how often, & the router takes care of matching messages to
+them, checking arguments, enforcing cooldowns & generating `help from the declarations.
+*/
+package commands
+
+import (
+	"fmt"
+	"github.com/strider-/dreamer/roles"
+	"github.com/strider-/dreamer/secrets"
+	"github.com/strider-/irc"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Where a command can be used
+type Scope int
+
+const (
+	InChannel Scope = 1 << iota
+	InPrivate
+
+	Anywhere = InChannel | InPrivate
+)
+
+type Command struct {
+	Name    string
+	Aliases []string
+	// arguments after the name, for usage messages; e.g. "p1 [, p2]"
+	Usage string
+	Help  string
+	// the least role that can use it
+	Role  roles.Role
+	Where Scope
+	// what the arguments are split on; empty means they're taken as a single argument,
+	// " " that they're split on whitespace. A MaxArgs of 0 takes no arguments at all.
+	Split            string
+	MinArgs, MaxArgs int
+	Cooldown         Cooldown
+	Run              func(c *Context)
+}
+
+// A command being run
+type Context struct {
+	Msg  *irc.Message
+	Cmd  *Command
+	Args []string
+	// the network it came from, & the channel; empty for private messages
+	Network, Channel string
+	// the role of whoever sent it
+	Role roles.Role
+	// where replies go; the channel for channel messages, the sender otherwise
+	ReplyTo string
+	router  *Router
+}
+
+func (c *Context) Reply(msg string) {
+	c.router.Send(c.Network, c.ReplyTo, msg)
+}
+
+func (c *Context) Replyf(format string, args ...interface{}) {
+	c.Reply(fmt.Sprintf(format, args...))
+}
+
+// Replies with what failed & why. Errors can carry anything (a connection string, a query with a
+// password in it), so any secret in one is redacted before it goes out on IRC.
+func (c *Context) ReplyErr(what string, err error) {
+	c.Replyf("%s: %s", what, secrets.Redact(err.Error()))
+}
+
+type Router struct {
+	Prefix string
+	// sends a message to a channel or nick on a network
+	Send func(network, target, msg string)
+	// works out the role of whoever sent m, at least as far as need, passing it to then; which
+	// can be later on, once NickServ has vouched for them. Everyone's a user if unset.
+	RoleOf func(network string, m *irc.Message, need roles.Role, then func(role roles.Role))
+	// whether a command is answered in a channel on a network; all of them are if unset
+	Enabled func(network, channel string, c *Command) bool
+	// the cooldowns a command is held to; its own if unset
+	CooldownOf func(c *Command) Cooldown
+	// told when someone's turned away by a cooldown, once per wait; they're quietly ignored if unset
+	OnCooldown func(ctx *Context, wait time.Duration)
+
+	commands  []*Command
+	names     map[string]*Command
+	cooldowns *cooldowns
+}
+
+// A router for commands starting with prefix, with `help already added
+func NewRouter(prefix string, send func(network, target, msg string)) *Router {
+	r := &Router{Prefix: prefix, Send: send, names: make(map[string]*Command), cooldowns: newCooldowns()}
+	r.Add(&Command{
+		Name:    "help",
+		Aliases: []string{"commands"},
+		Usage:   "[command]",
+		Help:    "Lists the commands you can use, or explains one",
+		Where:   Anywhere,
+		MaxArgs: 1,
+		Run:     r.help,
+	})
+	return r
+}
+
+// Adds a command, refusing it if its name or any alias is already taken
+func (r *Router) Add(c *Command) error {
+	for _, name := range append([]string{c.Name}, c.Aliases...) {
+		if other, ok := r.names[strings.ToLower(name)]; ok {
+			return fmt.Errorf("%s%s is already taken by %s%s", r.Prefix, name, r.Prefix, other.Name)
+		}
+	}
+	for _, name := range append([]string{c.Name}, c.Aliases...) {
+		r.names[strings.ToLower(name)] = c
+	}
+	r.commands = append(r.commands, c)
+	return nil
+}
+
+// Looks up a command by name or alias
+func (r *Router) Lookup(name string) (*Command, bool) {
+	c, ok := r.names[strings.ToLower(strings.TrimPrefix(name, r.Prefix))]
+	return c, ok
+}
+
+// Runs whichever command m, from network, is if it's one the sender can use where they sent it;
+// possibly later on, if their role needs checking. Returns whether m was a command at all.
+func (r *Router) Handle(network string, m *irc.Message) bool {
+	if !strings.HasPrefix(m.Trail, r.Prefix) {
+		return false
+	}
+	parts := strings.SplitN(strings.TrimPrefix(m.Trail, r.Prefix), " ", 2)
+	c, ok := r.Lookup(parts[0])
+	if !ok {
+		return false
+	}
+
+	ctx := &Context{Msg: m, Cmd: c, Network: network, ReplyTo: m.Nick, router: r}
+	if m.IsChannelMsg() {
+		ctx.Channel = m.Parameters[0]
+		ctx.ReplyTo = ctx.Channel
+		if c.Where&InChannel == 0 || !r.enabled(network, ctx.Channel, c) {
+			return true
+		}
+	} else if c.Where&InPrivate == 0 {
+		return true
+	}
+
+	var rest string
+	if len(parts) > 1 {
+		rest = strings.TrimSpace(parts[1])
+	}
+	// trusted users & up aren't held to cooldowns, but whether someone is only needs working out
+	// (which can mean asking NickServ) when a cooldown would turn them away
+	cooldown := r.cooldownOf(c)
+	need := c.Role
+	if need < roles.Trusted && r.cooldowns.wait(c.Name, network, m.Nick, ctx.Channel, cooldown, time.Now()) > 0 {
+		need = roles.Trusted
+	}
+	r.roleOf(network, m, need, func(role roles.Role) {
+		ctx.Role = role
+		if role < c.Role {
+			return
+		}
+		// usage messages count against the cooldown too, or they'd be a way around it
+		if role < roles.Trusted {
+			now := time.Now()
+			if wait := r.cooldowns.take(c.Name, network, m.Nick, ctx.Channel, cooldown, now); wait > 0 {
+				if r.OnCooldown != nil && r.cooldowns.notice(c.Name, network, m.Nick, wait, now) {
+					r.OnCooldown(ctx, wait)
+				}
+				return
+			}
+		}
+		ctx.Args = splitArgs(rest, c.Split, c.MaxArgs)
+		if len(ctx.Args) < c.MinArgs || len(ctx.Args) > c.MaxArgs {
+			ctx.Replyf("Usage: %s", r.usage(c))
+			return
+		}
+		c.Run(ctx)
+	})
+	return true
+}
+
+func (r *Router) roleOf(network string, m *irc.Message, need roles.Role, then func(role roles.Role)) {
+	if r.RoleOf == nil {
+		then(roles.User)
+		return
+	}
+	r.RoleOf(network, m, need, then)
+}
+
+func (r *Router) enabled(network, channel string, c *Command) bool {
+	return r.Enabled == nil || r.Enabled(network, channel, c)
+}
+
+func (r *Router) cooldownOf(c *Command) Cooldown {
+	if r.CooldownOf == nil {
+		return c.Cooldown
+	}
+	return r.CooldownOf(c)
+}
+
+func (r *Router) usage(c *Command) string {
+	if c.Usage == "" {
+		return r.Prefix + c.Name
+	}
+	return fmt.Sprintf("%s%s %s", r.Prefix, c.Name, c.Usage)
+}
+
+// handles `help, listing only what the asker can use where they asked, as far as their role is
+// known without asking NickServ
+func (r *Router) help(ctx *Context) {
+	usable := func(c *Command) bool {
+		if ctx.Channel == "" {
+			return c.Role <= ctx.Role && c.Where&InPrivate != 0
+		}
+		return c.Role <= ctx.Role && c.Where&InChannel != 0 && r.enabled(ctx.Network, ctx.Channel, c)
+	}
+	if len(ctx.Args) == 1 {
+		c, ok := r.Lookup(ctx.Args[0])
+		if !ok || !usable(c) {
+			ctx.Replyf("No such command %s", ctx.Args[0])
+			return
+		}
+		msg := fmt.Sprintf("%s - %s", r.usage(c), c.Help)
+		if len(c.Aliases) > 0 {
+			msg += fmt.Sprintf(" (also %s%s)", r.Prefix, strings.Join(c.Aliases, ", "+r.Prefix))
+		}
+		ctx.Reply(msg)
+		return
+	}
+
+	names := make([]string, 0, len(r.commands))
+	for _, c := range r.commands {
+		if usable(c) {
+			names = append(names, r.Prefix+c.Name)
+		}
+	}
+	sort.Strings(names)
+	ctx.Replyf("Commands: %s | %shelp command for more", strings.Join(names, " "), r.Prefix)
+}
+
+// Splits the arguments on sep, leaving anything past max in the last one
+func splitArgs(rest, sep string, max int) []string {
+	if rest == "" {
+		return nil
+	}
+	switch sep {
+	case "":
+		return []string{rest}
+	case " ":
+		return strings.Fields(rest)
+	}
+	if max < 1 {
+		max = 1
+	}
+	args := strings.SplitN(rest, sep, max)
+	for i := range args {
+		args[i] = strings.TrimSpace(args[i])
+	}
+	return args
+}
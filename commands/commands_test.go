@@ -0,0 +1,166 @@
+package commands
+
+import (
+	"github.com/strider-/dreamer/roles"
+	"github.com/strider-/irc"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		rest, sep string
+		max       int
+		want      []string
+	}{
+		{"", "", 1, nil},
+		{"", " ", 3, nil},
+		{"", ",", 2, nil},
+		{"Some Fighter", "", 1, []string{"Some Fighter"}},
+		{"a  b c", " ", 3, []string{"a", "b", "c"}},
+		{"a b c", " ", 1, []string{"a", "b", "c"}},
+		{"Some Fighter , Another", ",", 2, []string{"Some Fighter", "Another"}},
+		{"a, b, c", ",", 2, []string{"a", "b, c"}},
+		{"a, b", ",", 0, []string{"a, b"}},
+		{"a,", ",", 2, []string{"a", ""}},
+	}
+	for _, tt := range tests {
+		if got := splitArgs(tt.rest, tt.sep, tt.max); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitArgs(%q, %q, %d) = %q, want %q", tt.rest, tt.sep, tt.max, got, tt.want)
+		}
+	}
+}
+
+// A router with a few commands, whose replies end up in sent as "network target: msg"
+func testRouter(sent *[]string) *Router {
+	r := NewRouter("!", func(network, target, msg string) {
+		*sent = append(*sent, network+" "+target+": "+msg)
+	})
+	r.RoleOf = func(network string, m *irc.Message, need roles.Role, then func(role roles.Role)) {
+		switch m.Nick {
+		case "boss":
+			then(roles.Owner)
+		case "mod":
+			then(roles.Admin)
+		default:
+			then(roles.User)
+		}
+	}
+	r.Enabled = func(network, channel string, c *Command) bool {
+		return channel != "#quiet" || c.Name == "help"
+	}
+	reply := func(c *Context) { c.Reply(c.Cmd.Name + " " + strings.Join(c.Args, "|")) }
+	r.Add(&Command{Name: "stats", Aliases: []string{"s"}, Usage: "p1 [, p2]", Help: "Looks fighters up",
+		Where: Anywhere, Split: ",", MinArgs: 1, MaxArgs: 2, Run: reply})
+	r.Add(&Command{Name: "ping", Help: "Pongs", Where: InPrivate, Run: reply})
+	r.Add(&Command{Name: "chan", Help: "Channel only", Where: InChannel, Run: reply})
+	r.Add(&Command{Name: "quit", Help: "Quits", Role: roles.Admin, Where: Anywhere, Run: reply})
+	r.Add(&Command{Name: "roll", Help: "Rolls", Where: Anywhere, Cooldown: Cooldown{User: time.Minute}, Run: reply})
+	return r
+}
+
+func message(nick, to, trail string) *irc.Message {
+	return &irc.Message{Nick: nick, Command: irc.CMD_PRIVMSG, Parameters: []string{to}, Trail: trail}
+}
+
+func TestRouterHandle(t *testing.T) {
+	tests := []struct {
+		nick, to, trail string
+		handled         bool
+		want            []string
+	}{
+		{"joe", "#salt", "hello", false, nil},
+		{"joe", "#salt", "!nope", false, nil},
+		{"joe", "#salt", "!stats a , b", true, []string{"rizon #salt: stats a|b"}},
+		{"joe", "#salt", "!S a", true, []string{"rizon #salt: stats a"}},
+		{"joe", "#salt", "!stats a, b, c", true, []string{"rizon #salt: stats a|b, c"}},
+		{"joe", "#salt", "!stats", true, []string{"rizon #salt: Usage: !stats p1 [, p2]"}},
+		{"joe", "dreamer", "!stats a", true, []string{"rizon joe: stats a"}},
+		// not answered where it isn't allowed, but still a command
+		{"joe", "#quiet", "!stats a", true, nil},
+		{"joe", "#salt", "!ping", true, nil},
+		{"joe", "dreamer", "!ping", true, []string{"rizon joe: ping "}},
+		{"joe", "dreamer", "!ping now", true, []string{"rizon joe: Usage: !ping"}},
+		{"joe", "dreamer", "!chan", true, nil},
+		{"joe", "#salt", "!quit", true, nil},
+		{"joe", "#salt", "!quit now", true, nil},
+		{"mod", "#salt", "!quit", true, []string{"rizon #salt: quit "}},
+		{"joe", "#salt", "!help", true, []string{"rizon #salt: Commands: !chan !help !roll !stats | !help command for more"}},
+		{"joe", "#quiet", "!help", true, []string{"rizon #quiet: Commands: !help | !help command for more"}},
+		{"joe", "dreamer", "!help", true, []string{"rizon joe: Commands: !help !ping !roll !stats | !help command for more"}},
+		{"mod", "dreamer", "!commands", true, []string{"rizon mod: Commands: !help !ping !quit !roll !stats | !help command for more"}},
+		{"joe", "#salt", "!help s", true, []string{"rizon #salt: !stats p1 [, p2] - Looks fighters up (also !s)"}},
+		{"joe", "#salt", "!help !ping", true, []string{"rizon #salt: No such command !ping"}},
+		{"joe", "#salt", "!help quit", true, []string{"rizon #salt: No such command quit"}},
+		{"boss", "#salt", "!help quit", true, []string{"rizon #salt: !quit - Quits"}},
+		{"joe", "#quiet", "!help stats", true, []string{"rizon #quiet: No such command stats"}},
+	}
+	for _, tt := range tests {
+		var sent []string
+		r := testRouter(&sent)
+		if handled := r.Handle("rizon", message(tt.nick, tt.to, tt.trail)); handled != tt.handled {
+			t.Errorf("%s to %s %q: handled = %v, want %v", tt.nick, tt.to, tt.trail, handled, tt.handled)
+		}
+		if !reflect.DeepEqual(sent, tt.want) {
+			t.Errorf("%s to %s %q: sent %q, want %q", tt.nick, tt.to, tt.trail, sent, tt.want)
+		}
+	}
+}
+
+// Trusted users & up skip cooldowns, but whether someone is only matters once a cooldown's running
+func TestRouterRoleNeeded(t *testing.T) {
+	tests := []struct {
+		before []string
+		trail  string
+		want   roles.Role
+	}{
+		{nil, "!stats a", roles.User},
+		{nil, "!quit", roles.Admin},
+		{nil, "!help", roles.User},
+		{nil, "!roll", roles.User},
+		{[]string{"!roll"}, "!roll", roles.Trusted},
+		{[]string{"!roll"}, "!stats a", roles.User},
+	}
+	for _, tt := range tests {
+		var sent []string
+		r := testRouter(&sent)
+		for _, trail := range tt.before {
+			r.Handle("rizon", message("joe", "#salt", trail))
+		}
+		var need roles.Role
+		r.RoleOf = func(network string, m *irc.Message, n roles.Role, then func(role roles.Role)) { need = n }
+		r.Handle("rizon", message("joe", "#salt", tt.trail))
+		if need != tt.want {
+			t.Errorf("%q after %q needed the role worked out as far as %v, want %v", tt.trail, tt.before, need, tt.want)
+		}
+	}
+}
+
+func TestRouterCooldowns(t *testing.T) {
+	var sent []string
+	r := testRouter(&sent)
+	notices := 0
+	r.OnCooldown = func(ctx *Context, wait time.Duration) {
+		notices++
+		if wait <= 0 || wait > time.Minute {
+			t.Errorf("told to wait %v", wait)
+		}
+	}
+	for _, nick := range []string{"joe", "joe", "joe", "ann", "mod", "mod"} {
+		r.Handle("rizon", message(nick, "#salt", "!roll"))
+	}
+	// a usage message counts as a use
+	r.Handle("rizon", message("sue", "#salt", "!roll a b"))
+	r.Handle("rizon", message("sue", "#salt", "!roll"))
+
+	want := []string{"rizon #salt: roll ", "rizon #salt: roll ", "rizon #salt: roll ", "rizon #salt: roll ",
+		"rizon #salt: Usage: !roll"}
+	if !reflect.DeepEqual(sent, want) {
+		t.Errorf("sent %q, want %q", sent, want)
+	}
+	if notices != 2 {
+		t.Errorf("%d cooldown notices, want one each for joe & sue", notices)
+	}
+}
@@ -0,0 +1,98 @@
+package commands
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// how many cooldowns are kept before the ones that have run out are thrown away
+	PRUNE_AT int = 256
+)
+
+// How long after being used before a command can be used again
+type Cooldown struct {
+	// by anyone, anywhere
+	Command time.Duration
+	// by the same user
+	User time.Duration
+	// in the same channel
+	Channel time.Duration
+}
+
+// When each running cooldown runs out, by command & who or where it applies to
+type cooldowns struct {
+	mu    sync.Mutex
+	until map[string]time.Time
+}
+
+func newCooldowns() *cooldowns {
+	return &cooldowns{until: make(map[string]time.Time)}
+}
+
+// How long until the command name can be used by nick in channel (empty for private messages)
+// on network, without starting anything
+func (cd *cooldowns) wait(name, network, nick, channel string, c Cooldown, now time.Time) time.Duration {
+	cd.mu.Lock()
+	defer cd.mu.Unlock()
+	return cd.longest(keys(name, network, nick, channel, c), now)
+}
+
+// Like wait, but if the command can be used now its cooldowns are started
+func (cd *cooldowns) take(name, network, nick, channel string, c Cooldown, now time.Time) time.Duration {
+	keys := keys(name, network, nick, channel, c)
+	cd.mu.Lock()
+	defer cd.mu.Unlock()
+	if wait := cd.longest(keys, now); wait > 0 {
+		return wait
+	}
+
+	for key, d := range keys {
+		if d > 0 {
+			cd.until[key] = now.Add(d)
+		}
+	}
+	// forget the ones that have run out, so there isn't one kept for every nick ever seen
+	if len(cd.until) > PRUNE_AT {
+		for key, until := range cd.until {
+			if !until.After(now) {
+				delete(cd.until, key)
+			}
+		}
+	}
+	return 0
+}
+
+// The cooldowns a use of a command starts, by key
+func keys(name, network, nick, channel string, c Cooldown) map[string]time.Duration {
+	keys := map[string]time.Duration{name: c.Command}
+	keys[name+" user "+network+" "+strings.ToLower(nick)] = c.User
+	if channel != "" {
+		keys[name+" channel "+network+" "+strings.ToLower(channel)] = c.Channel
+	}
+	return keys
+}
+
+// The longest any of keys has left to run; the caller holds the lock
+func (cd *cooldowns) longest(keys map[string]time.Duration, now time.Time) (wait time.Duration) {
+	for key := range keys {
+		if left := cd.until[key].Sub(now); left > wait {
+			wait = left
+		}
+	}
+	return
+}
+
+// Whether nick should be told about a cooldown they've run into on network, which they are once
+// for every wait rather than every time they try
+func (cd *cooldowns) notice(name, network, nick string, wait time.Duration, now time.Time) bool {
+	key := name + " notice " + network + " " + strings.ToLower(nick)
+	cd.mu.Lock()
+	defer cd.mu.Unlock()
+	if cd.until[key].After(now) {
+		return false
+	}
+	cd.until[key] = now.Add(wait)
+	return true
+}
@@ -0,0 +1,123 @@
+package commands
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestCooldownsTake(t *testing.T) {
+	start := time.Date(2014, time.January, 1, 12, 0, 0, 0, time.UTC)
+	type use struct {
+		name, network, nick, channel string
+		after                        time.Duration
+		want                         time.Duration
+	}
+	tests := []struct {
+		cooldown Cooldown
+		uses     []use
+	}{
+		// nothing to wait for without a cooldown
+		{Cooldown{}, []use{
+			{"stats", "rizon", "joe", "#salt", 0, 0},
+			{"stats", "rizon", "joe", "#salt", 0, 0},
+		}},
+		{Cooldown{Command: time.Minute}, []use{
+			{"stats", "rizon", "joe", "#salt", 0, 0},
+			{"stats", "rizon", "ann", "#other", 20 * time.Second, 40 * time.Second},
+			{"stats", "esper", "bob", "", 30 * time.Second, 10 * time.Second},
+			{"roll", "rizon", "joe", "#salt", 30 * time.Second, 0},
+			{"stats", "rizon", "joe", "#salt", time.Minute, 0},
+		}},
+		{Cooldown{User: time.Minute}, []use{
+			{"stats", "rizon", "joe", "#salt", 0, 0},
+			{"stats", "rizon", "JOE", "", 10 * time.Second, 50 * time.Second},
+			{"stats", "rizon", "ann", "#salt", 10 * time.Second, 0},
+			// the same nick on another network is someone else
+			{"stats", "esper", "joe", "#salt", 10 * time.Second, 0},
+			{"stats", "rizon", "joe", "#salt", time.Minute, 0},
+		}},
+		{Cooldown{Channel: time.Minute}, []use{
+			{"stats", "rizon", "joe", "#salt", 0, 0},
+			{"stats", "rizon", "ann", "#SALT", 15 * time.Second, 45 * time.Second},
+			{"stats", "rizon", "ann", "#other", 15 * time.Second, 0},
+			{"stats", "esper", "ann", "#salt", 15 * time.Second, 0},
+			// private messages aren't in a channel
+			{"stats", "rizon", "ann", "", 15 * time.Second, 0},
+		}},
+		// the longest wait is the one that counts, & being turned away doesn't start anything
+		{Cooldown{Command: 10 * time.Second, User: time.Minute, Channel: 30 * time.Second}, []use{
+			{"stats", "rizon", "joe", "#salt", 0, 0},
+			{"stats", "rizon", "joe", "#salt", 5 * time.Second, 55 * time.Second},
+			{"stats", "rizon", "ann", "#salt", 5 * time.Second, 20 * time.Second},
+			{"stats", "rizon", "ann", "#other", 10 * time.Second, 0},
+			{"stats", "rizon", "bob", "#salt", 30 * time.Second, 0},
+		}},
+	}
+	for i, tt := range tests {
+		cd := newCooldowns()
+		now := start
+		for j, u := range tt.uses {
+			now = now.Add(u.after)
+			if got := cd.take(u.name, u.network, u.nick, u.channel, tt.cooldown, now); got != u.want {
+				t.Errorf("%d.%d: %s by %s in %q on %s waits %v, want %v", i, j, u.name, u.nick, u.channel, u.network, got, u.want)
+			}
+		}
+	}
+}
+
+func TestCooldownsWait(t *testing.T) {
+	cd := newCooldowns()
+	now := time.Date(2014, time.January, 1, 12, 0, 0, 0, time.UTC)
+	c := Cooldown{User: time.Minute}
+	for i, want := range []time.Duration{0, 0} {
+		if got := cd.wait("stats", "rizon", "joe", "#salt", c, now); got != want {
+			t.Errorf("%d: wait = %v, want %v", i, got, want)
+		}
+	}
+	cd.take("stats", "rizon", "joe", "#salt", c, now)
+	if got := cd.wait("stats", "rizon", "joe", "#salt", c, now.Add(15*time.Second)); got != 45*time.Second {
+		t.Errorf("wait after a use = %v, want 45s", got)
+	}
+	if got := cd.wait("stats", "rizon", "ann", "#salt", c, now); got != 0 {
+		t.Errorf("wait for someone else = %v, want 0", got)
+	}
+}
+
+func TestCooldownsPrune(t *testing.T) {
+	cd := newCooldowns()
+	now := time.Now()
+	for i := 0; i <= PRUNE_AT; i++ {
+		cd.take("stats", "rizon", fmt.Sprint("nick", i), "", Cooldown{User: time.Second}, now)
+	}
+	cd.take("stats", "rizon", "joe", "", Cooldown{User: time.Second}, now.Add(time.Minute))
+	if len(cd.until) != 1 {
+		t.Errorf("%d cooldowns kept, want only the running one", len(cd.until))
+	}
+}
+
+func TestCooldownsNotice(t *testing.T) {
+	start := time.Date(2014, time.January, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name, network, nick string
+		wait, after         time.Duration
+		want                bool
+	}{
+		{"stats", "rizon", "joe", time.Minute, 0, true},
+		{"stats", "rizon", "Joe", 50 * time.Second, 10 * time.Second, false},
+		{"stats", "rizon", "ann", 50 * time.Second, 0, true},
+		{"stats", "esper", "joe", 50 * time.Second, 0, true},
+		{"roll", "rizon", "joe", 50 * time.Second, 0, true},
+		{"stats", "rizon", "joe", 10 * time.Second, 40 * time.Second, false},
+		// once the wait they were told about is up they're told again
+		{"stats", "rizon", "joe", 30 * time.Second, 10 * time.Second, true},
+	}
+	cd := newCooldowns()
+	now := start
+	for i, tt := range tests {
+		now = now.Add(tt.after)
+		if got := cd.notice(tt.name, tt.network, tt.nick, tt.wait, now); got != tt.want {
+			t.Errorf("%d: notice(%s, %s, %s) = %v, want %v", i, tt.name, tt.network, tt.nick, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+/*
+Fighter details from their compendium pages; author, life, meter & whatever else salty lists,
+along with every change to them. Written by the scraper, read by dreamer & the IRC bot.
+*/
+package compendium
+
+import (
+	"database/sql"
+	"github.com/strider-/dreamer/storage"
+	"sort"
+	"time"
+)
+
+const (
+	CREATE_ATTRIBUTES_SQL string = `CREATE TABLE IF NOT EXISTS fighter_attributes (
+		fighter_id integer NOT NULL,
+		name varchar(64) NOT NULL,
+		value varchar(255) NOT NULL,
+		updated timestamp NOT NULL,
+		PRIMARY KEY (fighter_id, name))`
+	CREATE_ATTRIBUTE_HISTORY_SQL string = `CREATE TABLE IF NOT EXISTS fighter_attribute_history (
+		id serial PRIMARY KEY,
+		fighter_id integer NOT NULL,
+		name varchar(64) NOT NULL,
+		old_value varchar(255) NOT NULL,
+		new_value varchar(255) NOT NULL,
+		changed timestamp NOT NULL)`
+	CREATE_CHECKS_SQL string = `CREATE TABLE IF NOT EXISTS fighter_attribute_checks (
+		fighter_id integer PRIMARY KEY,
+		checked timestamp NOT NULL)`
+)
+
+// A fighter attribute going from Old to New. Newly listed attributes have an empty Old,
+// ones salty stopped listing an empty New.
+type Change struct {
+	Attribute string
+	Old, New  string
+	Changed   time.Time
+}
+
+// Creates the attribute tables if need be. Stores without SQL have nowhere to keep them.
+func CreateTables(db storage.Store) error {
+	if _, ok := db.(storage.SQLStore); !ok {
+		return nil
+	}
+	return storage.WithTrans(db, func(tx *sql.Tx) error {
+		for _, q := range []string{CREATE_ATTRIBUTES_SQL, CREATE_ATTRIBUTE_HISTORY_SQL, CREATE_CHECKS_SQL} {
+			if _, err := tx.Exec(q); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// A fighter's current attributes, by name; empty for stores without SQL
+func Load(db storage.Store, fighterId int) (map[string]string, error) {
+	attrs := make(map[string]string)
+	if _, ok := db.(storage.SQLStore); !ok {
+		return attrs, nil
+	}
+	err := storage.WithTrans(db, func(tx *sql.Tx) error {
+		var err error
+		attrs, err = load(tx, fighterId)
+		return err
+	})
+	return attrs, err
+}
+
+// Every recorded change to a fighter's attributes, oldest first
+func History(db storage.Store, fighterId int) (changes []Change, err error) {
+	if _, ok := db.(storage.SQLStore); !ok {
+		return nil, nil
+	}
+	err = storage.WithTrans(db, func(tx *sql.Tx) error {
+		rows, e := tx.Query(`SELECT name, old_value, new_value, changed FROM fighter_attribute_history
+			WHERE fighter_id = $1 ORDER BY changed, id`, fighterId)
+		if e != nil {
+			return e
+		}
+		defer rows.Close()
+		for rows.Next() {
+			c := Change{}
+			if e := rows.Scan(&c.Attribute, &c.Old, &c.New, &c.Changed); e != nil {
+				return e
+			}
+			changes = append(changes, c)
+		}
+		return rows.Err()
+	})
+	return
+}
+
+// When each fighter's compendium page was last scraped, by fighter id
+func Checked(tx *sql.Tx) (map[int]time.Time, error) {
+	rows, err := tx.Query(`SELECT fighter_id, checked FROM fighter_attribute_checks`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	checked := make(map[int]time.Time)
+	for rows.Next() {
+		var id int
+		var at time.Time
+		if err := rows.Scan(&id, &at); err != nil {
+			return nil, err
+		}
+		checked[id] = at
+	}
+	return checked, rows.Err()
+}
+
+// Notes that a fighter's compendium page was just scraped, whether or not anything was on it
+func MarkChecked(tx *sql.Tx, fighterId int) error {
+	now := time.Now()
+	res, err := tx.Exec(`UPDATE fighter_attribute_checks SET checked = $2 WHERE fighter_id = $1`, fighterId, now)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		return nil
+	}
+	_, err = tx.Exec(`INSERT INTO fighter_attribute_checks (fighter_id, checked) VALUES ($1, $2)`, fighterId, now)
+	return err
+}
+
+// Replaces a fighter's attributes with attrs as part of tx, recording whatever changed
+func Save(tx *sql.Tx, fighterId int, attrs map[string]string) (changes []Change, err error) {
+	current, err := load(tx, fighterId)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	for _, name := range Names(attrs) {
+		old, listed := current[name]
+		if listed && old == attrs[name] {
+			continue
+		}
+		changes = append(changes, Change{Attribute: name, Old: old, New: attrs[name], Changed: now})
+		if listed {
+			_, err = tx.Exec(`UPDATE fighter_attributes SET value = $3, updated = $4 WHERE fighter_id = $1 AND name = $2`,
+				fighterId, name, attrs[name], now)
+		} else {
+			_, err = tx.Exec(`INSERT INTO fighter_attributes (fighter_id, name, value, updated) VALUES ($1, $2, $3, $4)`,
+				fighterId, name, attrs[name], now)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, name := range Names(current) {
+		if _, listed := attrs[name]; listed {
+			continue
+		}
+		changes = append(changes, Change{Attribute: name, Old: current[name], Changed: now})
+		if _, err = tx.Exec(`DELETE FROM fighter_attributes WHERE fighter_id = $1 AND name = $2`, fighterId, name); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, c := range changes {
+		_, err = tx.Exec(`INSERT INTO fighter_attribute_history (fighter_id, name, old_value, new_value, changed)
+			VALUES ($1, $2, $3, $4, $5)`, fighterId, c.Attribute, c.Old, c.New, c.Changed)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err = MarkChecked(tx, fighterId); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+func load(tx *sql.Tx, fighterId int) (map[string]string, error) {
+	rows, err := tx.Query(`SELECT name, value FROM fighter_attributes WHERE fighter_id = $1`, fighterId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attrs := make(map[string]string)
+	for rows.Next() {
+		var name, value string
+		if err := rows.Scan(&name, &value); err != nil {
+			return nil, err
+		}
+		attrs[name] = value
+	}
+	return attrs, rows.Err()
+}
+
+// Attribute names in alphabetical order, so changes are always recorded & listed the same way
+func Names(attrs map[string]string) []string {
+	list := make([]string, 0, len(attrs))
+	for name := range attrs {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+	return list
+}
@@ -0,0 +1,435 @@
+/*
+The config shared by dreamer, the scraper & the IRC bot, read from the file named by ME_CONF.
+Every key is checked up front against what the program needs, & everything wrong is reported
+at once by name, instead of a typo turning into an interface conversion panic later on.
+Secrets (see the secrets package) are resolved as the config is loaded.
+*/
+package config
+
+import (
+	"fmt"
+	"github.com/strider-/dreamer/roles"
+	"github.com/strider-/dreamer/secrets"
+	"io"
+	"math"
+	"sort"
+	"spicerack"
+	"strings"
+	"time"
+)
+
+const (
+	ENV_VAR              string = "ME_CONF"
+	DEFAULT_PUSHOVER     string = "https://api.pushover.net/1/messages.json"
+	PLAINTEXT_WARNING    string = "%s is in the config as plain text, use env:VARIABLE or file:/path instead"
+	NO_PUSHOVER_WARNING  string = "pushover isn't configured, no notifications will be sent"
+	SHORT_SECRET_WARNING string = "%s is shorter than %d characters, too short to be scrubbed from logs"
+)
+
+// Which of the programs reading the config a key matters to
+type Program int
+
+const (
+	Dreamer Program = 1 << iota
+	Scraper
+	Bot
+
+	All     = Dreamer | Scraper | Bot
+	Nothing = Program(0)
+)
+
+func (p Program) String() string {
+	switch p {
+	case Dreamer:
+		return "dreamer"
+	case Scraper:
+		return "scraper"
+	case Bot:
+		return "bot"
+	case All:
+		return "all"
+	}
+	return "some"
+}
+
+// The salty section
+type Salty struct {
+	DbBackend, DbUser, DbPass, DbName string
+	IllumEmail, IllumPword            string
+	TheShiznit, AjaxStats             string
+	RecentTournamentCount             int
+	Server, Channel, Nick, Pass       string
+	BotEmail, Admin, AccountCheck     string
+	Services                          string
+	CooldownNotice                    bool
+	Websocket                         string
+	WlAddr, WlUser, WlPass            string
+	GoogleApiKey                      string
+}
+
+// The pushover section, for the bot's crash notifications
+type Pushover struct {
+	Url, UserKey, IrcToken string
+}
+
+// Whether there's enough to send a notification with
+func (p Pushover) Enabled() bool {
+	return p.Url != "" && p.UserKey != "" && p.IrcToken != ""
+}
+
+type Config struct {
+	Salty
+	Pushover Pushover
+	// where the bot is, & what it does in each channel
+	Networks []Network
+	// the bot's message templates, by name; it knows which names & verbs are allowed
+	Formats map[string]string
+	// the bot's command cooldowns, keyed by command name (or * for every command), with
+	// .user or .channel on the end for per user & per channel ones
+	Cooldowns map[string]time.Duration
+	// who has which of the bot's roles, by role name; account:NAME entries or hostmasks
+	Roles map[string][]string
+	// things worth a mention that don't stop anything running
+	Warnings []string
+	// the whole config, for sections a program reads for itself
+	Raw *spicerack.Gofig
+}
+
+// Everything wrong with a config, reported all at once
+type Problems []string
+
+func (p Problems) Error() string {
+	return fmt.Sprintf("%d problem(s) with the config:\n  %s", len(p), strings.Join(p, "\n  "))
+}
+
+// A key in the config. Keys a program uses but doesn't require fall back on def.
+type field struct {
+	section, key string
+	uses         Program
+	required     Program
+	def          interface{}
+	secret       bool
+	dest         func(c *Config) interface{}
+}
+
+var fields = []field{
+	{"salty", "db_backend", All, Nothing, "postgres", false, func(c *Config) interface{} { return &c.DbBackend }},
+	{"salty", "db_name", All, All, "", false, func(c *Config) interface{} { return &c.DbName }},
+	{"salty", "db_user", All, Nothing, "", false, func(c *Config) interface{} { return &c.DbUser }},
+	{"salty", "db_pass", All, Nothing, "", true, func(c *Config) interface{} { return &c.DbPass }},
+	{"salty", "illum_email", Dreamer | Scraper, Dreamer | Scraper, "", false, func(c *Config) interface{} { return &c.IllumEmail }},
+	{"salty", "illum_pword", Dreamer | Scraper, Dreamer | Scraper, "", true, func(c *Config) interface{} { return &c.IllumPword }},
+	{"salty", "the_shiznit", Dreamer | Bot, Dreamer | Bot, "", false, func(c *Config) interface{} { return &c.TheShiznit }},
+	{"salty", "ajax_stats", Dreamer, Dreamer, "", false, func(c *Config) interface{} { return &c.AjaxStats }},
+	{"salty", "recent_tournament_count", Scraper, Scraper, 0, false, func(c *Config) interface{} { return &c.RecentTournamentCount }},
+	// only needed without a networks section
+	{"salty", "server", Bot, Nothing, "", false, func(c *Config) interface{} { return &c.Server }},
+	{"salty", "channel", Bot, Nothing, "", false, func(c *Config) interface{} { return &c.Channel }},
+	{"salty", "nick", Bot, Nothing, "", false, func(c *Config) interface{} { return &c.Nick }},
+	{"salty", "pass", Bot, Nothing, "", true, func(c *Config) interface{} { return &c.Pass }},
+	{"salty", "bot_email", Bot, Nothing, "", false, func(c *Config) interface{} { return &c.BotEmail }},
+	{"salty", "admin", Bot, Nothing, "", false, func(c *Config) interface{} { return &c.Admin }},
+	{"salty", "account_check", Bot, Nothing, roles.CHECK_ACC, false, func(c *Config) interface{} { return &c.AccountCheck }},
+	{"salty", "services", Bot, Nothing, roles.DEFAULT_SERVICES, false, func(c *Config) interface{} { return &c.Services }},
+	{"salty", "cooldown_notice", Bot, Nothing, false, false, func(c *Config) interface{} { return &c.CooldownNotice }},
+	{"salty", "websocket", Bot, Bot, "", false, func(c *Config) interface{} { return &c.Websocket }},
+	{"salty", "wl_addr", Bot, Nothing, "", false, func(c *Config) interface{} { return &c.WlAddr }},
+	{"salty", "wl_user", Bot, Nothing, "", false, func(c *Config) interface{} { return &c.WlUser }},
+	{"salty", "wl_pass", Bot, Nothing, "", true, func(c *Config) interface{} { return &c.WlPass }},
+	{"salty", "google_api_key", Bot, Nothing, "", true, func(c *Config) interface{} { return &c.GoogleApiKey }},
+	{"pushover", "url", Bot, Nothing, DEFAULT_PUSHOVER, false, func(c *Config) interface{} { return &c.Pushover.Url }},
+	{"pushover", "user_key", Bot, Nothing, "", true, func(c *Config) interface{} { return &c.Pushover.UserKey }},
+	{"pushover", "irc_token", Bot, Nothing, "", true, func(c *Config) interface{} { return &c.Pushover.IrcToken }},
+}
+
+// Loads & checks the config for program p, resolving its secrets. Every problem found comes
+// back together as Problems.
+func Load(p Program) (*Config, error) {
+	conf, err := spicerack.GofigFromEnv(ENV_VAR)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read the config named by %s: %v", ENV_VAR, err)
+	}
+	c, problems := parse(conf, p)
+	c.Raw = conf
+	if len(problems) > 0 {
+		return c, problems
+	}
+	return c, nil
+}
+
+// Whether args ask for the config to be checked, i.e. `config check`
+func IsCheckCommand(args []string) bool {
+	return len(args) == 2 && args[0] == "config" && args[1] == "check"
+}
+
+// Checks the config for program p, along with any checks of the program's own, writing out
+// every problem & warning. Returns whether it's good to go.
+func Check(w io.Writer, p Program, extra ...func(c *Config) Problems) bool {
+	c, err := Load(p)
+	if c != nil {
+		problems, _ := err.(Problems)
+		for _, check := range extra {
+			problems = append(problems, check(c)...)
+		}
+		if len(problems) > 0 {
+			err = problems
+		}
+		for _, warning := range c.Warnings {
+			fmt.Fprintf(w, "Warning: %s\n", warning)
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(w, err)
+		return false
+	}
+	fmt.Fprintf(w, "Config is good for the %s program(s).\n", p)
+	return true
+}
+
+// Where the config's sections come from; a *spicerack.Gofig outside of tests
+type sectioned interface {
+	Map(section string) (map[string]interface{}, error)
+}
+
+func parse(conf sectioned, p Program) (*Config, Problems) {
+	c := &Config{}
+	var problems Problems
+	sections := make(map[string]map[string]interface{})
+	known := make(map[string]bool)
+	for _, f := range fields {
+		if _, ok := sections[f.section]; !ok {
+			// a missing section is as good as an empty one, the keys it should have are reported
+			sections[f.section], _ = conf.Map(f.section)
+		}
+		name := f.section + "." + f.key
+		known[name] = true
+
+		// a wrong type only stops the programs that use the key, the rest hear about it
+		v, present := sections[f.section][f.key]
+		if present {
+			if err := assign(f.dest(c), v); err != nil {
+				if f.uses&p != 0 {
+					problems = append(problems, fmt.Sprintf("%s %v", name, err))
+				} else {
+					c.Warnings = append(c.Warnings, fmt.Sprintf("%s %v", name, err))
+				}
+				continue
+			}
+		}
+		if f.uses&p == 0 {
+			continue
+		}
+		if !present || isEmpty(v) {
+			if f.required&p != 0 {
+				problems = append(problems, fmt.Sprintf("%s is missing", name))
+				continue
+			}
+			assign(f.dest(c), f.def)
+			continue
+		}
+
+		if f.secret {
+			if err := c.resolveSecret(name, f.dest(c).(*string)); err != nil {
+				problems = append(problems, err.Error())
+			}
+		}
+	}
+
+	problems = append(problems, c.validate(p)...)
+
+	if p&Bot != 0 {
+		networks, _ := conf.Map("networks")
+		problems = append(problems, c.parseNetworks(networks)...)
+	}
+
+	c.Formats = make(map[string]string)
+	formats, _ := conf.Map("formats")
+	for _, key := range sortedKeys(formats) {
+		s, ok := formats[key].(string)
+		if !ok {
+			if p&Bot != 0 {
+				problems = append(problems, fmt.Sprintf("formats.%s should be text, not %s", key, describe(formats[key])))
+			}
+			continue
+		}
+		c.Formats[key] = s
+	}
+
+	c.Cooldowns = make(map[string]time.Duration)
+	cooldowns, _ := conf.Map("cooldowns")
+	for _, key := range sortedKeys(cooldowns) {
+		d, err := toDuration(cooldowns[key])
+		if err == nil {
+			if i := strings.Index(key, "."); i >= 0 && key[i:] != ".user" && key[i:] != ".channel" {
+				err = fmt.Errorf("should be a command name, with .user or .channel on the end if anything")
+			}
+		}
+		if err != nil {
+			if p&Bot != 0 {
+				problems = append(problems, fmt.Sprintf("cooldowns.%s %v", key, err))
+			}
+			continue
+		}
+		c.Cooldowns[key] = d
+	}
+
+	c.Roles = make(map[string][]string)
+	section, _ := conf.Map("roles")
+	for _, key := range sortedKeys(section) {
+		// unlike elsewhere, "*" doesn't mean everyone
+		entries, err := toList(section[key])
+		if _, isList := section[key].([]interface{}); !isList {
+			err = fmt.Errorf("should be a list of account:NAME entries or hostmasks")
+		}
+		if err != nil {
+			if p&Bot != 0 {
+				problems = append(problems, fmt.Sprintf("roles.%s %v", key, err))
+			}
+			continue
+		}
+		c.Roles[key] = entries
+	}
+	if p&Bot != 0 && c.Admin != "" {
+		c.Warnings = append(c.Warnings, "salty.admin is only there for old configs, it's better as an account:NAME owner in the roles section")
+	}
+
+	// keys nobody reads are most likely typos of ones somebody does
+	for section, values := range sections {
+		for _, key := range sortedKeys(values) {
+			if !known[section+"."+key] {
+				c.Warnings = append(c.Warnings, fmt.Sprintf("%s.%s isn't a known setting, is it misspelled?", section, key))
+			}
+		}
+	}
+	if p&Bot != 0 && !c.Pushover.Enabled() {
+		c.Warnings = append(c.Warnings, NO_PUSHOVER_WARNING)
+	}
+	sort.Strings(c.Warnings)
+	return c, problems
+}
+
+// Swaps the secret config value at s for the secret itself, warning if it was written in as is
+func (c *Config) resolveSecret(name string, s *string) error {
+	if secrets.IsPlaintext(*s) {
+		c.Warnings = append(c.Warnings, fmt.Sprintf(PLAINTEXT_WARNING, name))
+	}
+	secret, err := secrets.Resolve(name, *s)
+	if err != nil {
+		return err
+	}
+	if secret != "" && len(secret) < secrets.MIN_REDACT_LEN {
+		c.Warnings = append(c.Warnings, fmt.Sprintf(SHORT_SECRET_WARNING, name, secrets.MIN_REDACT_LEN))
+	}
+	*s = secret
+	return nil
+}
+
+// Checks that need more than one key, or more than the key's type
+func (c *Config) validate(p Program) (problems Problems) {
+	switch c.DbBackend {
+	case "":
+		// wrong type, already reported
+	case "postgres":
+		if c.DbUser == "" {
+			problems = append(problems, "salty.db_user is missing, postgres needs it")
+		}
+	case "sqlite":
+	case "memory":
+		if p&Scraper != 0 {
+			problems = append(problems, "salty.db_backend can't be memory for the scraper, it has nowhere to keep what it scrapes")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("salty.db_backend should be postgres, sqlite or memory, not '%s'", c.DbBackend))
+	}
+	if p&Scraper != 0 && c.RecentTournamentCount < 0 {
+		problems = append(problems, "salty.recent_tournament_count can't be negative")
+	}
+	return
+}
+
+// Sets dest (a *string or *int) to the config value v, if it's the right type
+func assign(dest, v interface{}) error {
+	switch d := dest.(type) {
+	case *string:
+		s, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("should be text, not %s", describe(v))
+		}
+		*d = s
+	case *int:
+		n, ok := toInt(v)
+		if !ok {
+			return fmt.Errorf("should be a whole number, not %s", describe(v))
+		}
+		*d = n
+	case *bool:
+		b, ok := v.(bool)
+		if !ok {
+			return fmt.Errorf("should be true or false, not %s", describe(v))
+		}
+		*d = b
+	}
+	return nil
+}
+
+// Durations are written like "30s" or "2m"
+func toDuration(v interface{}) (time.Duration, error) {
+	s, ok := v.(string)
+	if !ok {
+		return 0, fmt.Errorf("should be a duration like \"30s\", not %s", describe(v))
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("should be a duration like \"30s\", not '%s'", s)
+	}
+	return d, nil
+}
+
+// JSON numbers come through as float64s
+func toInt(v interface{}) (int, bool) {
+	switch n := v.(type) {
+	case int:
+		return n, true
+	case int64:
+		return int(n), true
+	case float64:
+		if n == math.Trunc(n) {
+			return int(n), true
+		}
+	}
+	return 0, false
+}
+
+// Unset, for the purposes of falling back on a default
+func isEmpty(v interface{}) bool {
+	s, ok := v.(string)
+	return v == nil || ok && s == ""
+}
+
+// What a config value looks like, for problem messages. Never the value itself, it could be a secret.
+func describe(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "text"
+	case bool:
+		return "true/false"
+	case int, int64, float64:
+		return "a number"
+	case map[string]interface{}:
+		return "a section"
+	case []interface{}:
+		return "a list"
+	}
+	return fmt.Sprintf("a %T", v)
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
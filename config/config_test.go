@@ -0,0 +1,165 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"testing"
+)
+
+// A config read straight from JSON, the way spicerack reads the file
+type sections map[string]map[string]interface{}
+
+func (s sections) Map(section string) (map[string]interface{}, error) {
+	m, ok := s[section]
+	if !ok {
+		return nil, fmt.Errorf("no %s section", section)
+	}
+	return m, nil
+}
+
+const (
+	SCRAPER_CONF string = `{"salty": {
+		"db_name": "salty", "db_user": "salty", "illum_email": "me@example.com",
+		"illum_pword": "env:DREAMER_TEST_PWORD", "recent_tournament_count": 5 %s}}`
+	BOT_CONF string = `{
+	"salty": {"db_name": "salty", "db_user": "salty", "the_shiznit": "x", "websocket": "ws://example.com",
+		"server": "irc.example.com:6667", "nick": "dreamer", "channel": "#salt"},
+	"pushover": {"user_key": "env:DREAMER_TEST_PWORD", "irc_token": "env:DREAMER_TEST_PWORD"} %s}`
+)
+
+func TestParse(t *testing.T) {
+	os.Setenv("DREAMER_TEST_PWORD", "hunter2")
+	defer os.Unsetenv("DREAMER_TEST_PWORD")
+	os.Setenv("DREAMER_TEST_SHORT", "pw")
+	defer os.Unsetenv("DREAMER_TEST_SHORT")
+
+	tests := []struct {
+		name     string
+		p        Program
+		conf     string
+		problems []string
+		warnings []string
+	}{
+		{"a complete scraper config", Scraper, fmt.Sprintf(SCRAPER_CONF, ""), nil, nil},
+		{"an empty salty section", Scraper, `{"salty": {}}`, []string{
+			"salty.db_name is missing",
+			"salty.illum_email is missing",
+			"salty.illum_pword is missing",
+			"salty.recent_tournament_count is missing",
+			"salty.db_user is missing, postgres needs it",
+		}, nil},
+		// a missing section is reported by its keys
+		{"no sections at all", Dreamer, `{}`, []string{
+			"salty.db_name is missing",
+			"salty.illum_email is missing",
+			"salty.illum_pword is missing",
+			"salty.the_shiznit is missing",
+			"salty.ajax_stats is missing",
+			"salty.db_user is missing, postgres needs it",
+		}, nil},
+		{"an unknown backend", Scraper, fmt.Sprintf(SCRAPER_CONF, `, "db_backend": "mysql"`), []string{
+			"salty.db_backend should be postgres, sqlite or memory, not 'mysql'",
+		}, nil},
+		{"memory for the scraper", Scraper, fmt.Sprintf(SCRAPER_CONF, `, "db_backend": "memory"`), []string{
+			"salty.db_backend can't be memory for the scraper, it has nowhere to keep what it scrapes",
+		}, nil},
+		{"a negative tournament count", Scraper, `{"salty": {"db_name": "salty", "db_backend": "sqlite", "illum_email": "me@example.com",
+			"illum_pword": "env:DREAMER_TEST_PWORD", "recent_tournament_count": -1}}`, []string{
+			"salty.recent_tournament_count can't be negative",
+		}, nil},
+		{"wrong types", Scraper, `{"salty": {"db_name": 5, "db_user": "salty", "illum_email": "me@example.com",
+			"illum_pword": "env:DREAMER_TEST_PWORD", "recent_tournament_count": 1.5}}`, []string{
+			"salty.db_name should be text, not a number",
+			"salty.recent_tournament_count should be a whole number, not a number",
+		}, nil},
+		// a wrong type only stops the programs using the key
+		{"a wrong type the program doesn't use", Dreamer, `{"salty": {"db_name": "salty", "db_user": "salty", "illum_email": "me@example.com",
+			"illum_pword": "env:DREAMER_TEST_PWORD", "the_shiznit": "x", "ajax_stats": "y",
+			"recent_tournament_count": "lots"}}`, nil, []string{
+			"salty.recent_tournament_count should be a whole number, not text",
+		}},
+		{"plaintext secrets", Scraper, `{"salty": {"db_name": "salty", "db_user": "salty", "db_pass": "hunter2", "illum_email": "me@example.com",
+			"illum_pword": "hunter2", "recent_tournament_count": 5}}`, nil, []string{
+			"salty.db_pass is in the config as plain text, use env:VARIABLE or file:/path instead",
+			"salty.illum_pword is in the config as plain text, use env:VARIABLE or file:/path instead",
+		}},
+		{"a secret too short to redact", Scraper, `{"salty": {"db_name": "salty", "db_user": "salty", "db_pass": "env:DREAMER_TEST_SHORT",
+			"illum_email": "me@example.com", "illum_pword": "env:DREAMER_TEST_PWORD", "recent_tournament_count": 5}}`, nil, []string{
+			"salty.db_pass is shorter than 6 characters, too short to be scrubbed from logs",
+		}},
+		{"a misspelled key", Scraper, fmt.Sprintf(SCRAPER_CONF, `, "db_nmae": "salty", "nick": "dreamer"`), nil, []string{
+			"salty.db_nmae isn't a known setting, is it misspelled?",
+		}},
+		{"a complete bot config", Bot, fmt.Sprintf(BOT_CONF, ""), nil, nil},
+		{"a bot without a server or owner", Bot, `{"salty": {"db_name": "salty", "db_user": "salty", "the_shiznit": "x", "websocket": "ws://example.com",
+			"admin": "Lone_Strider"}}`, []string{
+			"salty.server is missing (or add a networks section)",
+			"salty.nick is missing (or add a networks section)",
+			"salty.channel is missing (or add a networks section)",
+		}, []string{
+			NO_PUSHOVER_WARNING,
+			"salty.admin is only there for old configs, it's better as an account:NAME owner in the roles section",
+		}},
+		{"bad cooldowns & roles", Bot, fmt.Sprintf(BOT_CONF, `, "cooldowns": {"stats": "1m", "stats.nick": "1m", "*.user": "soon"},
+			"roles": {"owner": "account:Lone_Strider", "admin": [1]}`), []string{
+			`cooldowns.*.user should be a duration like "30s", not 'soon'`,
+			"cooldowns.stats.nick should be a command name, with .user or .channel on the end if anything",
+			"roles.admin should only have names in it, not a number",
+			"roles.owner should be a list of account:NAME entries or hostmasks",
+		}, nil},
+		{"a broken network", Bot, fmt.Sprintf(BOT_CONF, `, "networks": {"rizon": {"server": "irc.rizon.net:6667", "account_check": "whois",
+			"services": "services.rizon.net", "channels": {"#salt": null}, "chanels": {}}}`), []string{
+			"networks.rizon.nick is missing",
+			"networks.rizon.account_check should be acc or status, not 'whois'",
+			"networks.rizon.services should be a nick!user@host mask, not 'services.rizon.net'",
+		}, []string{
+			"networks.rizon.chanels isn't a known setting, is it misspelled?",
+		}},
+	}
+	for _, tt := range tests {
+		var conf sections
+		if err := json.Unmarshal([]byte(tt.conf), &conf); err != nil {
+			t.Fatalf("%s: bad test config: %v", tt.name, err)
+		}
+		c, problems := parse(conf, tt.p)
+		if !sameStrings(problems, tt.problems) {
+			t.Errorf("%s: problems for the %s = %q, want %q", tt.name, tt.p, problems, tt.problems)
+		}
+		if !sameStrings(c.Warnings, tt.warnings) {
+			t.Errorf("%s: warnings for the %s = %q, want %q", tt.name, tt.p, c.Warnings, tt.warnings)
+		}
+	}
+}
+
+func TestParseResolvesSecrets(t *testing.T) {
+	os.Setenv("DREAMER_TEST_PWORD", "hunter2")
+	defer os.Unsetenv("DREAMER_TEST_PWORD")
+
+	var conf sections
+	json.Unmarshal([]byte(fmt.Sprintf(SCRAPER_CONF, "")), &conf)
+	c, problems := parse(conf, Scraper)
+	if len(problems) > 0 {
+		t.Fatal(problems)
+	}
+	if c.IllumPword != "hunter2" || c.DbBackend != "postgres" || c.RecentTournamentCount != 5 {
+		t.Errorf("got password %q, backend %q & count %d", c.IllumPword, c.DbBackend, c.RecentTournamentCount)
+	}
+}
+
+// Same strings in any order
+func sameStrings(got, want []string) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	counts := make(map[string]int)
+	for _, s := range got {
+		counts[s]++
+	}
+	for _, s := range want {
+		if counts[s]--; counts[s] < 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,199 @@
+package config
+
+import (
+	"fmt"
+	"github.com/strider-/dreamer/roles"
+)
+
+// An IRC network for the bot to be on, from the networks section:
+//
+//	"networks": {
+//		"esper": {
+//			"server": "irc.esper.net:6667", "nick": "salt_shaker", "pass": "env:SHAKER_PASS", "account_check": "acc",
+//			"services": "NickServ!NickServ@services.esper.net",
+//			"channels": {
+//				"#team": {},
+//				"#public": {"commands": ["s", "help"], "announcements": ["cards", "winners"]}
+//			}
+//		}
+//	}
+//
+// Without a networks section, the salty section's server, nick, pass & channel make a network called default.
+// Either way the pass is optional; without one the bot doesn't identify with NickServ. Services is
+// the nick!user@host mask NickServ's notices have to come from, roles.DEFAULT_SERVICES if it isn't set.
+type Network struct {
+	Name               string
+	Server, Nick, Pass string
+	// how NickServ's asked who someone's logged in as; acc for atheme, status for anope
+	AccountCheck string
+	Services     string
+	Channels     []Channel
+}
+
+// A channel on a network, & what the bot does there
+type Channel struct {
+	Name string
+	// the commands answered & announcements made here, by name; nil for all of them
+	Commands, Announcements []string
+}
+
+// Whether the command is answered in the channel
+func (ch Channel) Allows(command string) bool {
+	return ch.Commands == nil || contains(ch.Commands, command)
+}
+
+// Whether the kind of announcement is made in the channel
+func (ch Channel) Announces(kind string) bool {
+	return ch.Announcements == nil || contains(ch.Announcements, kind)
+}
+
+// The network called name, if there is one
+func (c *Config) Network(name string) (Network, bool) {
+	for _, n := range c.Networks {
+		if n.Name == name {
+			return n, true
+		}
+	}
+	return Network{}, false
+}
+
+// The channel called name on a network, if the bot's meant to be in it
+func (n Network) Channel(name string) (Channel, bool) {
+	for _, ch := range n.Channels {
+		if ch.Name == name {
+			return ch, true
+		}
+	}
+	return Channel{}, false
+}
+
+// Reads the networks section, or makes the default network out of the salty section without one
+func (c *Config) parseNetworks(section map[string]interface{}) (problems Problems) {
+	if len(section) == 0 {
+		legacy := []struct{ key, value string }{{"server", c.Server}, {"nick", c.Nick}, {"channel", c.Channel}}
+		for _, l := range legacy {
+			if l.value == "" {
+				problems = append(problems, fmt.Sprintf("salty.%s is missing (or add a networks section)", l.key))
+			}
+		}
+		if c.AccountCheck != roles.CHECK_ACC && c.AccountCheck != roles.CHECK_STATUS {
+			problems = append(problems, fmt.Sprintf("salty.account_check should be acc or status, not '%s'", c.AccountCheck))
+		}
+		if !roles.IsMask(c.Services) {
+			problems = append(problems, fmt.Sprintf("salty.services should be a nick!user@host mask, not '%s'", c.Services))
+		}
+		c.Networks = []Network{{
+			Name: "default", Server: c.Server, Nick: c.Nick, Pass: c.Pass, AccountCheck: c.AccountCheck, Services: c.Services,
+			Channels: []Channel{{Name: c.Channel}},
+		}}
+		return
+	}
+
+	for _, name := range sortedKeys(section) {
+		prefix := "networks." + name
+		values, ok := section[name].(map[string]interface{})
+		if !ok {
+			problems = append(problems, fmt.Sprintf("%s should be a section, not %s", prefix, describe(section[name])))
+			continue
+		}
+
+		n := Network{Name: name, AccountCheck: roles.CHECK_ACC, Services: roles.DEFAULT_SERVICES}
+		settings := []struct {
+			key      string
+			dest     *string
+			required bool
+		}{{"server", &n.Server, true}, {"nick", &n.Nick, true}, {"pass", &n.Pass, false}, {"account_check", &n.AccountCheck, false}, {"services", &n.Services, false}}
+		for _, s := range settings {
+			v, present := values[s.key]
+			if !present || isEmpty(v) {
+				if s.required {
+					problems = append(problems, fmt.Sprintf("%s.%s is missing", prefix, s.key))
+				}
+				continue
+			}
+			if err := assign(s.dest, v); err != nil {
+				problems = append(problems, fmt.Sprintf("%s.%s %v", prefix, s.key, err))
+			}
+		}
+		if n.Pass != "" {
+			if err := c.resolveSecret(prefix+".pass", &n.Pass); err != nil {
+				problems = append(problems, err.Error())
+			}
+		}
+		if n.AccountCheck != roles.CHECK_ACC && n.AccountCheck != roles.CHECK_STATUS {
+			problems = append(problems, fmt.Sprintf("%s.account_check should be acc or status, not '%s'", prefix, n.AccountCheck))
+		}
+		if !roles.IsMask(n.Services) {
+			problems = append(problems, fmt.Sprintf("%s.services should be a nick!user@host mask, not '%s'", prefix, n.Services))
+		}
+
+		channels, ok := values["channels"].(map[string]interface{})
+		if !ok || len(channels) == 0 {
+			problems = append(problems, fmt.Sprintf("%s.channels is missing, the bot needs somewhere to be", prefix))
+		}
+		for _, chName := range sortedKeys(channels) {
+			ch, more := parseChannel(prefix+".channels."+chName, chName, channels[chName])
+			problems = append(problems, more...)
+			n.Channels = append(n.Channels, ch)
+		}
+		for _, key := range sortedKeys(values) {
+			if key != "server" && key != "nick" && key != "pass" && key != "account_check" && key != "services" && key != "channels" {
+				c.Warnings = append(c.Warnings, fmt.Sprintf("%s.%s isn't a known setting, is it misspelled?", prefix, key))
+			}
+		}
+		c.Networks = append(c.Networks, n)
+	}
+	return
+}
+
+// An empty (or null) channel section gets every command & announcement
+func parseChannel(prefix, name string, v interface{}) (ch Channel, problems Problems) {
+	ch.Name = name
+	if v == nil {
+		return
+	}
+	values, ok := v.(map[string]interface{})
+	if !ok {
+		return ch, Problems{fmt.Sprintf("%s should be a section, not %s", prefix, describe(v))}
+	}
+	var err error
+	if ch.Commands, err = toList(values["commands"]); err != nil {
+		problems = append(problems, fmt.Sprintf("%s.commands %v", prefix, err))
+	}
+	if ch.Announcements, err = toList(values["announcements"]); err != nil {
+		problems = append(problems, fmt.Sprintf("%s.announcements %v", prefix, err))
+	}
+	return
+}
+
+// A list of names; nil (meaning all of them) if it isn't there, or is "*"
+func toList(v interface{}) ([]string, error) {
+	switch l := v.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		if l == "*" {
+			return nil, nil
+		}
+	case []interface{}:
+		names := make([]string, 0, len(l))
+		for _, item := range l {
+			name, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("should only have names in it, not %s", describe(item))
+			}
+			names = append(names, name)
+		}
+		return names, nil
+	}
+	return nil, fmt.Errorf("should be a list of names or \"*\", not %s", describe(v))
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -12,44 +12,82 @@ import (
 	"code.google.com/p/gorest"
 	"flag"
 	"fmt"
+	"github.com/strider-/dreamer/compendium"
+	"github.com/strider-/dreamer/config"
+	"github.com/strider-/dreamer/relay"
+	"github.com/strider-/dreamer/roster"
+	"github.com/strider-/dreamer/secrets"
+	"github.com/strider-/dreamer/session"
+	"github.com/strider-/dreamer/storage"
+	"io"
 	"net"
 	"net/http"
 	"net/http/fcgi"
+	"os"
 	"sort"
 	"spicerack"
+	"time"
 )
 
 var (
 	fastcgi                = flag.Bool("fcgi", false, "Run under FastCGI mode")
 	dbUser, dbPass, dbName string
+	dbBackend              string
+	db                     storage.Store
 	illumEmail, illumPass  string
 	theShiznit, statsUrl   string
-	webClient              *http.Client
+	sessions               *session.Manager
+	out                    io.Writer = secrets.NewWriter(os.Stdout)
 )
 
 func main() {
 	flag.Parse()
+	if config.IsCheckCommand(flag.Args()) {
+		if !config.Check(out, config.Dreamer) {
+			os.Exit(1)
+		}
+		return
+	}
 	loadConfig()
 	gorest.RegisterService(new(DreamService))
 	var err error
 
-	webClient, err = spicerack.LogIntoSaltyBet(illumEmail, illumPass)
+	db, err = storage.Open(dbBackend, dbUser, dbPass, dbName)
 	if err != nil {
-		fmt.Printf("Error logging into Salty Bet: %v\n", err)
+		fmt.Fprintf(out, "Failed to open %s storage: %v\n", dbBackend, err)
+		os.Exit(1)
+	}
+	defer db.Close()
+	if err = compendium.CreateTables(db); err != nil {
+		fmt.Fprintf(out, "Failed to create fighter detail tables: %v\n", err)
+	}
+	if err = roster.CreateTable(db); err != nil {
+		fmt.Fprintf(out, "Failed to create fighter status table: %v\n", err)
+	}
+
+	// keep an eye on the saltybet login, so an expired cookie or lapsed subscription gets
+	// fixed or announced instead of quietly serving empty stats
+	sessions = session.NewManager(illumEmail, illumPass, 30*time.Second)
+	sessions.Notify = func(msg string) {
+		fmt.Fprintln(out, msg)
+		if err := relay.ToBot(msg); err != nil {
+			fmt.Fprintf(out, "Failed to send message to IRC bot: %v\n", err)
+		}
 	}
+	go sessions.Watch(sessions.Interval, nil)
 
 	if !*fastcgi {
-		fmt.Println("Running Locally")
+		fmt.Fprintln(out, "Running Locally")
 		static := []string{"index", "search", "ds.js", "s.js", "ta.css"}
 		for _, p := range static {
 			http.HandleFunc(fmt.Sprintf("/%s", p), staticPage)
 		}
 		http.Handle("/", gorest.Handle())
-		fmt.Println(http.ListenAndServe(":9000", nil))
+		fmt.Fprintln(out, http.ListenAndServe(":9000", nil))
 	} else {
-		fmt.Println("Running as FastCGI")
+		fmt.Fprintln(out, "Running as FastCGI")
 		l, _ := net.Listen("tcp", ":9000")
-		fmt.Println(fcgi.Serve(l, gorest.Handle()))
+		fmt.Fprintln(out, fcgi.Serve(l, gorest.Handle()))
 	}
 }
 
@@ -61,32 +99,46 @@ func staticPage(w http.ResponseWriter, r *http.Request) {
 	http.ServeFile(w, r, file)
 }
 
+// Reads the salty section, quitting with everything that's wrong with it if it isn't usable
 func loadConfig() {
-	conf, _ := spicerack.GofigFromEnv("ME_CONF")
-	salty, _ := conf.Map("salty")
-	dbUser = salty["db_user"].(string)
-	dbName = salty["db_name"].(string)
-	dbPass = salty["db_pass"].(string)
-	illumEmail = salty["illum_email"].(string)
-	illumPass = salty["illum_pword"].(string)
-	theShiznit = salty["the_shiznit"].(string)
-	statsUrl = salty["ajax_stats"].(string)
+	settings, err := config.Load(config.Dreamer)
+	if settings != nil {
+		for _, warning := range settings.Warnings {
+			fmt.Fprintf(out, "Warning: %s\n", warning)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(out, "%v\nQuitting.\n", err)
+		os.Exit(1)
+	}
+	dbUser, dbName, dbPass = settings.DbUser, settings.DbName, settings.DbPass
+	illumEmail, illumPass = settings.IllumEmail, settings.IllumPword
+	theShiznit, statsUrl = settings.TheShiznit, settings.AjaxStats
+	dbBackend = settings.DbBackend
 }
 
 type DreamService struct {
 	gorest.RestService `root:"/api" consumes:"application/json" produces:"application/json"`
 
 	getFighters     gorest.EndPoint `method:"GET" path:"/a" output:"[]FighterInfo"`
-	getHistory      gorest.EndPoint `method:"GET" path:"/h/{CharId:int}" output:"spicerack.History"`
+	getAllFighters  gorest.EndPoint `method:"GET" path:"/a/all" output:"[]FighterInfo"`
+	getHistory      gorest.EndPoint `method:"GET" path:"/h/{CharId:int}" output:"FighterHistory"`
 	getCurrentFight gorest.EndPoint `method:"GET" path:"/f" output:"FightData"`
 }
 
 type FightData struct {
-	History []spicerack.History
+	History []storage.History
 	Stats   spicerack.FighterStats
 	Alert   string
 }
 
+// A fighter's record, along with their compendium details & how those have changed
+type FighterHistory struct {
+	storage.History
+	Attributes map[string]string
+	Changes    []compendium.Change
+}
+
 type FighterInfo struct {
 	Cid  int
 	Name string
@@ -98,18 +150,31 @@ func (f ByName) Len() int           { return len(f) }
 func (f ByName) Swap(i, j int)      { f[i], f[j] = f[j], f[i] }
 func (f ByName) Less(i, j int) bool { return f[i].Name < f[j].Name }
 
-func (serv DreamService) GetFighters() (fighters []FighterInfo) {
-	db := spicerack.Db(dbUser, dbPass, dbName)
-	defer db.Close()
+// Every fighter still on the tier list
+func (serv DreamService) GetFighters() []FighterInfo {
+	return serv.listFighters(false)
+}
+
+// Every fighter, retired or not
+func (serv DreamService) GetAllFighters() []FighterInfo {
+	return serv.listFighters(true)
+}
 
+func (serv DreamService) listFighters(includeRetired bool) (fighters []FighterInfo) {
 	names, err := db.GetFighterNames()
 	fighters = make([]FighterInfo, 0, len(names))
+	retired := make(map[string]bool)
+	if err == nil && !includeRetired {
+		retired, err = roster.Retired(db)
+	}
 	if err != nil {
 		serv.ResponseBuilder().SetResponseCode(500)
 	} else {
 		serv.ResponseBuilder().SetResponseCode(200)
 		for k, v := range names {
-			fighters = append(fighters, FighterInfo{Cid: k, Name: v})
+			if !retired[v] {
+				fighters = append(fighters, FighterInfo{Cid: k, Name: v})
+			}
 		}
 		sort.Sort(ByName(fighters))
 	}
@@ -117,29 +182,37 @@ func (serv DreamService) GetFighters() (fighters []FighterInfo) {
 	return
 }
 
-func (serv DreamService) GetHistory(CharId int) (h spicerack.History) {
-	db := spicerack.Db(dbUser, dbPass, dbName)
-	defer db.Close()
-
+func (serv DreamService) GetHistory(CharId int) (h FighterHistory) {
 	f, err := db.GetFighter(CharId)
 	if err != nil || f.Id == 0 {
 		serv.ResponseBuilder().SetResponseCode(404)
 		return
 	}
 
-	h = *db.GetHistory(f)
+	h.History = *db.GetHistory(f)
+	if h.Attributes, err = compendium.Load(db, f.Id); err != nil {
+		serv.ResponseBuilder().SetResponseCode(500)
+		return
+	}
+	if h.Changes, err = compendium.History(db, f.Id); err != nil {
+		serv.ResponseBuilder().SetResponseCode(500)
+		return
+	}
 	serv.ResponseBuilder().SetResponseCode(200)
 	return
 }
 
 func (serv DreamService) GetCurrentFight() FightData {
-	db := spicerack.Db(dbUser, dbPass, dbName)
-	defer db.Close()
 	fc, err := spicerack.GetSecretData(theShiznit)
 	if err != nil {
 		serv.ResponseBuilder().SetResponseCode(500)
 		return *new(FightData)
 	}
+	webClient, err := sessions.Client()
+	if err != nil {
+		serv.ResponseBuilder().SetResponseCode(503)
+		return *new(FightData)
+	}
 	fs, err := spicerack.GetFighterStats(webClient, statsUrl)
 	if err != nil {
 		serv.ResponseBuilder().SetResponseCode(500)
@@ -147,7 +220,7 @@ func (serv DreamService) GetCurrentFight() FightData {
 	}
 
 	card := &FightData{
-		History: make([]spicerack.History, 2),
+		History: make([]storage.History, 2),
 		Stats:   *fs,
 	}
 
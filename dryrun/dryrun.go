@@ -0,0 +1,160 @@
+/*
+What a -dry-run scrape would have changed, & what it skipped that a real run would have done.
+The scraper keeps its fighters here rather than writing them, so ratings build up across matches.
+*/
+package dryrun
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/strider-/dreamer/outcomes"
+	"github.com/strider-/dreamer/parser"
+	"io"
+	"sort"
+	"spicerack"
+)
+
+type Report struct {
+	NewFighters  []string
+	TierChanges  []TierChange
+	NewMatches   []ReportedMatch
+	RatingDeltas []RatingDelta
+	Retired      []string
+	Unretired    []string
+	DetailsDue   []string
+	SetupSkipped []string
+	fighters     map[string]*spicerack.Fighter
+	startElo     map[string]int
+	seen         map[int]bool
+}
+
+type TierChange struct {
+	Name     string
+	From, To int
+}
+
+type ReportedMatch struct {
+	MatchId           int
+	Red, Blue         string
+	Winner, Outcome   string
+	RedBets, BlueBets int
+}
+
+type RatingDelta struct {
+	Name            string
+	From, To, Delta int
+}
+
+func New() *Report {
+	return &Report{
+		fighters: make(map[string]*spicerack.Fighter),
+		startElo: make(map[string]int),
+		seen:     make(map[int]bool),
+	}
+}
+
+// The run's own copy of a fighter, or nil if they haven't been tracked yet
+func (r *Report) Fighter(name string) *spicerack.Fighter {
+	return r.fighters[name]
+}
+
+// Keeps f as the run's copy of the named fighter, rating changes counted from their elo as it is now.
+// A fighter not in the database yet should come with an empty name.
+func (r *Report) Track(name string, f *spicerack.Fighter) {
+	r.fighters[name] = f
+	r.startElo[name] = f.Elo
+}
+
+// Whether the run has already added the match
+func (r *Report) Seen(matchId int) bool {
+	return r.seen[matchId]
+}
+
+// Notes a compendium entry that's either brand new or changing tiers
+func (r *Report) AddRosterEntry(f *spicerack.Fighter, name string, tier int) {
+	if f.Id == 0 && f.Name == "" {
+		r.NewFighters = append(r.NewFighters, name)
+	} else if f.Tier != tier {
+		r.TierChanges = append(r.TierChanges, TierChange{Name: name, From: f.Tier, To: tier})
+	}
+}
+
+// Notes a match that would have been stored. Both fighters need tracking first.
+func (r *Report) AddMatch(pm *parser.Match) {
+	r.seen[pm.MatchId] = true
+	for _, name := range []string{pm.Red, pm.Blue} {
+		if f := r.fighters[name]; f.Id == 0 && f.Name == "" {
+			f.Name = name
+			r.NewFighters = append(r.NewFighters, name)
+		}
+	}
+	r.NewMatches = append(r.NewMatches, ReportedMatch{
+		MatchId: pm.MatchId, Red: pm.Red, Blue: pm.Blue, Winner: pm.Winner, Outcome: pm.Outcome,
+		RedBets: pm.RedBets, BlueBets: pm.BlueBets})
+}
+
+// Works out the net rating change for every fighter that would have fought
+func (r *Report) tallyRatings() {
+	r.RatingDeltas = nil
+	for name, f := range r.fighters {
+		if from := r.startElo[name]; f.Elo != from {
+			r.RatingDeltas = append(r.RatingDeltas, RatingDelta{Name: name, From: from, To: f.Elo, Delta: f.Elo - from})
+		}
+	}
+	sort.Sort(ByDelta(r.RatingDeltas))
+}
+
+// Prints the report to w, as json or plain text
+func (r *Report) Print(w io.Writer, format string) {
+	r.tallyRatings()
+	if format == "json" {
+		data, _ := json.MarshalIndent(r, "", "  ")
+		fmt.Fprintln(w, string(data))
+		return
+	}
+
+	fmt.Fprintln(w, "Dry run, nothing was committed.")
+	fmt.Fprintf(w, "New Fighters: %d\n", len(r.NewFighters))
+	for _, name := range r.NewFighters {
+		fmt.Fprintf(w, "- %s\n", name)
+	}
+	fmt.Fprintf(w, "Tier Changes: %d\n", len(r.TierChanges))
+	for _, t := range r.TierChanges {
+		fmt.Fprintf(w, "- %s: %d -> %d\n", t.Name, t.From, t.To)
+	}
+	fmt.Fprintf(w, "New Matches: %d\n", len(r.NewMatches))
+	for _, m := range r.NewMatches {
+		result := m.Outcome
+		if outcomes.Decided(m.Outcome) {
+			result = m.Winner + " wins"
+		}
+		fmt.Fprintf(w, "- #%d %s vs %s, %s ($%d / $%d)\n", m.MatchId, m.Red, m.Blue, result, m.RedBets, m.BlueBets)
+	}
+	fmt.Fprintf(w, "Rating Changes: %d\n", len(r.RatingDeltas))
+	for _, d := range r.RatingDeltas {
+		fmt.Fprintf(w, "- %s: %d -> %d (%+d)\n", d.Name, d.From, d.To, d.Delta)
+	}
+	for _, list := range []struct {
+		title string
+		names []string
+	}{
+		{"Retirements", r.Retired},
+		{"Back From Retirement", r.Unretired},
+		{"Fighter Details Not Fetched", r.DetailsDue},
+		{"Setup Skipped", r.SetupSkipped},
+	} {
+		fmt.Fprintf(w, "%s: %d\n", list.title, len(list.names))
+		for _, name := range list.names {
+			fmt.Fprintf(w, "- %s\n", name)
+		}
+	}
+}
+
+// biggest swings first
+type ByDelta []RatingDelta
+
+func (d ByDelta) Len() int      { return len(d) }
+func (d ByDelta) Swap(i, j int) { d[i], d[j] = d[j], d[i] }
+func (d ByDelta) Less(i, j int) bool {
+	return d[i].Delta*d[i].Delta > d[j].Delta*d[j].Delta
+}
@@ -0,0 +1,95 @@
+package dryrun
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/strider-/dreamer/outcomes"
+	"github.com/strider-/dreamer/parser"
+	"spicerack"
+	"strings"
+	"testing"
+)
+
+// A run over a roster update & a few matches, the way the scraper feeds it
+func runReport() *Report {
+	r := New()
+
+	ryu := &spicerack.Fighter{Id: 1, Name: "Ryu", Tier: 2, Elo: 1500}
+	r.AddRosterEntry(ryu, "Ryu", 1)
+	r.AddRosterEntry(&spicerack.Fighter{}, "Akuma", 3)
+	r.AddRosterEntry(&spicerack.Fighter{Id: 2, Name: "Ken", Tier: 2, Elo: 1400}, "Ken", 2)
+
+	r.Track("Ryu", ryu)
+	r.Track("Ken", &spicerack.Fighter{Id: 2, Name: "Ken", Elo: 1400})
+	r.Track("Dan", &spicerack.Fighter{})
+	r.AddMatch(&parser.Match{MatchId: 60000, Red: "Ryu", Blue: "Ken", Winner: "Ryu", Outcome: outcomes.RED,
+		RedBets: 100, BlueBets: 50})
+	r.AddMatch(&parser.Match{MatchId: 60001, Red: "Ken", Blue: "Dan", Winner: "Draw", Outcome: outcomes.DRAW})
+	r.AddMatch(&parser.Match{MatchId: 60002, Red: "Dan", Blue: "Ryu", Winner: "Ryu", Outcome: outcomes.BLUE})
+
+	// what rating the matches would have moved them to
+	r.Fighter("Ryu").Elo = 1530
+	r.Fighter("Ken").Elo = 1390
+	r.Fighter("Dan").Elo = 1480
+
+	r.Retired = []string{"Guile"}
+	r.SetupSkipped = []string{"create the scrape tables"}
+	return r
+}
+
+func TestTracking(t *testing.T) {
+	r := New()
+	if r.Fighter("Ryu") != nil || r.Seen(60000) {
+		t.Fatal("a new report already knows about fighters or matches")
+	}
+	ryu := &spicerack.Fighter{Name: "Ryu"}
+	r.Track("Ryu", ryu)
+	if r.Fighter("Ryu") != ryu {
+		t.Error("the tracked fighter didn't come back")
+	}
+	r.Track("Ken", &spicerack.Fighter{})
+	r.AddMatch(&parser.Match{MatchId: 60000, Red: "Ryu", Blue: "Ken"})
+	if !r.Seen(60000) || r.Seen(60001) {
+		t.Error("only the added match should be seen")
+	}
+	// a new fighter is only new once, however many matches they turn up in
+	r.AddMatch(&parser.Match{MatchId: 60001, Red: "Ken", Blue: "Ryu"})
+	if len(r.NewFighters) != 1 || r.NewFighters[0] != "Ken" || r.Fighter("Ken").Name != "Ken" {
+		t.Errorf("new fighters %v, want just Ken", r.NewFighters)
+	}
+}
+
+func TestPrint(t *testing.T) {
+	var buf bytes.Buffer
+	runReport().Print(&buf, "text")
+	text := buf.String()
+	for _, want := range []string{
+		"Dry run, nothing was committed.\n",
+		"New Fighters: 2\n- Akuma\n- Dan\n",
+		"Tier Changes: 1\n- Ryu: 2 -> 1\n",
+		"New Matches: 3\n- #60000 Ryu vs Ken, Ryu wins ($100 / $50)\n- #60001 Ken vs Dan, draw ($0 / $0)\n",
+		// biggest swings first, either direction
+		"Rating Changes: 3\n- Dan: 0 -> 1480 (+1480)\n- Ryu: 1500 -> 1530 (+30)\n- Ken: 1400 -> 1390 (-10)\n",
+		"Retirements: 1\n- Guile\n",
+		"Back From Retirement: 0\n",
+		"Setup Skipped: 1\n- create the scrape tables\n",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("the report is missing %q:\n%s", want, text)
+		}
+	}
+
+	buf.Reset()
+	runReport().Print(&buf, "json")
+	var got Report
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("the json report doesn't read back: %v\n%s", err, buf.String())
+	}
+	if len(got.NewFighters) != 2 || len(got.NewMatches) != 3 || len(got.RatingDeltas) != 3 ||
+		got.RatingDeltas[2].Delta != -10 || got.Retired[0] != "Guile" {
+		t.Errorf("the json report read back as %+v", got)
+	}
+	if strings.Contains(buf.String(), "fighters") || strings.Contains(buf.String(), "seen") {
+		t.Error("the json report includes the run's own bookkeeping")
+	}
+}
@@ -0,0 +1,152 @@
+/*
+Fetching pages from salty politely: a throttle on how often & how many at once, errors for the
+responses worth telling apart, & whether (& when) a failed fetch is worth another try.
+*/
+package fetch
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The login didn't take or the session expired; retrying won't help
+type AuthError struct {
+	Url    string
+	Status int
+}
+
+// Salty told us to slow down
+type RateLimitError struct {
+	Url        string
+	RetryAfter time.Duration
+}
+
+// Salty's having a bad day (5xx)
+type ServerError struct {
+	Url    string
+	Status int
+}
+
+// A page that loaded fine but had nothing on it
+type EmptyPageError struct{}
+
+func (e *AuthError) Error() string {
+	return fmt.Sprintf("not logged in to saltybet (status %d) fetching %s, check the illuminati credentials", e.Status, e.Url)
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("rate limited fetching %s", e.Url)
+}
+
+func (e *ServerError) Error() string {
+	return fmt.Sprintf("server error (status %d) fetching %s", e.Status, e.Url)
+}
+
+func (e *EmptyPageError) Error() string {
+	return "unable to find tournaments/fight records on the page"
+}
+
+// Keeps page fetches polite: one global request rate, a bit of random jitter
+// before each request & a cap on in-flight requests per host
+type Throttle struct {
+	ticks   <-chan time.Time
+	jitter  time.Duration
+	perHost int
+	mu      sync.Mutex
+	hosts   map[string]chan bool
+}
+
+// At most rate requests a second, each after up to jitter's random delay, with at most perHost
+// in flight to any one host
+func NewThrottle(rate float64, jitter time.Duration, perHost int) *Throttle {
+	if rate <= 0 {
+		rate = 1
+	}
+	if perHost < 1 {
+		perHost = 1
+	}
+	return &Throttle{
+		ticks:   time.Tick(time.Duration(float64(time.Second) / rate)),
+		jitter:  jitter,
+		perHost: perHost,
+		hosts:   make(map[string]chan bool),
+	}
+}
+
+// Blocks until a request to host is allowed, returning a func to call once it's finished
+func (t *Throttle) Acquire(host string) (release func()) {
+	t.mu.Lock()
+	slots, ok := t.hosts[host]
+	if !ok {
+		slots = make(chan bool, t.perHost)
+		t.hosts[host] = slots
+	}
+	t.mu.Unlock()
+
+	slots <- true
+	if t.jitter > 0 {
+		time.Sleep(time.Duration(rand.Int63n(int64(t.jitter))))
+	}
+	<-t.ticks
+	return func() { <-slots }
+}
+
+// Makes a single request, throttled if t isn't nil, turning bad status codes into errors
+func Page(c *http.Client, t *Throttle, pageUrl string) ([]byte, error) {
+	if t != nil {
+		if u, err := url.Parse(pageUrl); err == nil {
+			defer t.Acquire(u.Host)()
+		}
+	}
+
+	resp, err := c.Get(pageUrl)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return nil, &AuthError{Url: pageUrl, Status: resp.StatusCode}
+	case resp.StatusCode == http.StatusTooManyRequests:
+		secs, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+		return nil, &RateLimitError{Url: pageUrl, RetryAfter: time.Duration(secs) * time.Second}
+	case resp.StatusCode >= 500:
+		return nil, &ServerError{Url: pageUrl, Status: resp.StatusCode}
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("unexpected status %d fetching %s", resp.StatusCode, pageUrl)
+	case strings.Contains(resp.Request.URL.Path, "authenticate"):
+		// salty bounces logged out requests to the sign in page rather than a 401
+		return nil, &AuthError{Url: pageUrl, Status: resp.StatusCode}
+	}
+
+	return ioutil.ReadAll(resp.Body)
+}
+
+// Whether a fetch error is worth another try: rate limits, server errors & network trouble
+func Retryable(err error) bool {
+	switch err.(type) {
+	case *RateLimitError, *ServerError:
+		return true
+	case *AuthError, *EmptyPageError:
+		return false
+	}
+	_, isUrlErr := err.(*url.Error)
+	return isUrlErr
+}
+
+// Exponential backoff from one second (with a bit of jitter), or however long salty asked us to wait
+func Backoff(attempt int, err error) time.Duration {
+	if rl, ok := err.(*RateLimitError); ok && rl.RetryAfter > 0 {
+		return rl.RetryAfter
+	}
+	wait := time.Second << uint(attempt)
+	return wait + time.Duration(rand.Int63n(int64(wait/2)))
+}
@@ -0,0 +1,160 @@
+package fetch
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPage(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("<html></html>")) })
+	mux.HandleFunc("/authenticate", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("sign in")) })
+	mux.HandleFunc("/bounced", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/authenticate?signin=1", http.StatusFound)
+	})
+	mux.HandleFunc("/slow-down", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "7")
+		w.WriteHeader(http.StatusTooManyRequests)
+	})
+	for path, status := range map[string]int{"/401": 401, "/403": 403, "/404": 404, "/500": 500, "/503": 503, "/429": 429} {
+		status := status
+		mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(status) })
+	}
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	page, err := Page(server.Client(), nil, server.URL+"/ok")
+	if err != nil || string(page) != "<html></html>" {
+		t.Errorf("fetching a good page gave %q, %v", page, err)
+	}
+
+	for _, path := range []string{"/401", "/403", "/bounced"} {
+		if _, err := Page(server.Client(), nil, server.URL+path); !isAuth(err) {
+			t.Errorf("%s gave %v, want an AuthError", path, err)
+		}
+	}
+	for _, path := range []string{"/500", "/503"} {
+		if _, err := Page(server.Client(), nil, server.URL+path); !isServer(err) {
+			t.Errorf("%s gave %v, want a ServerError", path, err)
+		}
+	}
+
+	_, err = Page(server.Client(), nil, server.URL+"/slow-down")
+	if rl, ok := err.(*RateLimitError); !ok || rl.RetryAfter != 7*time.Second {
+		t.Errorf("a 429 with Retry-After gave %v, want a 7s RateLimitError", err)
+	}
+	_, err = Page(server.Client(), nil, server.URL+"/429")
+	if rl, ok := err.(*RateLimitError); !ok || rl.RetryAfter != 0 {
+		t.Errorf("a bare 429 gave %v, want a RateLimitError without a wait", err)
+	}
+
+	_, err = Page(server.Client(), nil, server.URL+"/404")
+	if err == nil || Retryable(err) {
+		t.Errorf("a 404 gave %v, want an error that isn't retried", err)
+	}
+}
+
+func isAuth(err error) bool {
+	_, ok := err.(*AuthError)
+	return ok
+}
+
+func isServer(err error) bool {
+	_, ok := err.(*ServerError)
+	return ok
+}
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&RateLimitError{Url: "x"}, true},
+		{&ServerError{Url: "x", Status: 502}, true},
+		{&url.Error{Op: "Get", URL: "x", Err: errors.New("connection reset")}, true},
+		{&AuthError{Url: "x", Status: 401}, false},
+		{&EmptyPageError{}, false},
+		{errors.New("unexpected status 404 fetching x"), false},
+	}
+	for _, tt := range tests {
+		if got := Retryable(tt.err); got != tt.want {
+			t.Errorf("Retryable(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempt  int
+		err      error
+		min, max time.Duration
+	}{
+		{0, &ServerError{}, time.Second, 1500 * time.Millisecond},
+		{1, &ServerError{}, 2 * time.Second, 3 * time.Second},
+		{3, &url.Error{Err: errors.New("timeout")}, 8 * time.Second, 12 * time.Second},
+		// salty's wait wins, whichever attempt it is
+		{0, &RateLimitError{RetryAfter: 30 * time.Second}, 30 * time.Second, 30 * time.Second},
+		{4, &RateLimitError{RetryAfter: 5 * time.Second}, 5 * time.Second, 5 * time.Second},
+		// no wait given, so the usual backoff
+		{2, &RateLimitError{}, 4 * time.Second, 6 * time.Second},
+	}
+	for _, tt := range tests {
+		// the jitter's random, so try a few
+		for i := 0; i < 20; i++ {
+			if got := Backoff(tt.attempt, tt.err); got < tt.min || got > tt.max {
+				t.Errorf("Backoff(%d, %v) = %v, want %v to %v", tt.attempt, tt.err, got, tt.min, tt.max)
+				break
+			}
+		}
+	}
+}
+
+func TestThrottlePerHost(t *testing.T) {
+	throttle := NewThrottle(1000, 0, 2)
+
+	var inFlight, most int32
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release := throttle.Acquire("www.saltybet.com")
+			n := atomic.AddInt32(&inFlight, 1)
+			for {
+				m := atomic.LoadInt32(&most)
+				if n <= m || atomic.CompareAndSwapInt32(&most, m, n) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+			atomic.AddInt32(&inFlight, -1)
+			release()
+		}()
+	}
+	wg.Wait()
+	if most > 2 {
+		t.Errorf("%d requests were in flight at once, over the cap of 2", most)
+	}
+
+	// another host has slots of its own
+	done := make(chan bool)
+	hold := throttle.Acquire("a.example")
+	hold2 := throttle.Acquire("a.example")
+	go func() {
+		throttle.Acquire("b.example")()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("a full host held up requests to another")
+	}
+	hold()
+	hold2()
+}
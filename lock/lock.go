@@ -0,0 +1,67 @@
+/*
+The scrape lock, which lives in the database so a cron run & the daemon can't overlap either.
+Whoever holds it sends heartbeats, & a lock without one for long enough is taken as abandoned.
+*/
+package lock
+
+import (
+	"database/sql"
+	"github.com/strider-/dreamer/storage"
+	"time"
+)
+
+const (
+	CREATE_LOCK_SQL string = `CREATE TABLE IF NOT EXISTS scrape_lock (
+		name varchar(32) PRIMARY KEY,
+		holder varchar(128) NOT NULL,
+		acquired timestamp NOT NULL,
+		heartbeat timestamp)`
+)
+
+// Takes the lock for holder if it's free, or its holder's last heartbeat (or the time they took it,
+// without one) is older than timeout. A single upsert, so two runs starting at once can't both see
+// an empty table.
+func Acquire(tx *sql.Tx, holder string, timeout time.Duration) (locked bool, err error) {
+	now := time.Now()
+	res, err := tx.Exec(`INSERT INTO scrape_lock (name, holder, acquired, heartbeat) VALUES ('scrape', $1, $2, $2)
+		ON CONFLICT (name) DO UPDATE SET holder = $1, acquired = $2, heartbeat = $2
+		WHERE scrape_lock.holder = '' OR COALESCE(scrape_lock.heartbeat, scrape_lock.acquired) < $3`,
+		holder, now, now.Add(-timeout))
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n == 1, nil
+}
+
+// Freshens holder's heartbeat, returning false if they don't hold the lock any more
+func Beat(tx *sql.Tx, holder string) (held bool, err error) {
+	res, err := tx.Exec(`UPDATE scrape_lock SET heartbeat = $2 WHERE name = 'scrape' AND holder = $1`, holder, time.Now())
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// Lets the lock go, if holder still has it
+func Release(tx *sql.Tx, holder string) error {
+	_, err := tx.Exec(`UPDATE scrape_lock SET holder = '' WHERE name = 'scrape' AND holder = $1`, holder)
+	return err
+}
+
+// Lock tables made before heartbeats don't have a column for one, so it's added to them. Checked
+// in a transaction of its own, since a failed statement spoils the rest of a postgres transaction.
+func AddHeartbeat(db storage.Store) error {
+	present := storage.WithTrans(db, func(tx *sql.Tx) error {
+		_, err := tx.Exec(`SELECT heartbeat FROM scrape_lock WHERE 1 = 0`)
+		return err
+	}) == nil
+	if present {
+		return nil
+	}
+	return storage.WithTrans(db, func(tx *sql.Tx) error {
+		_, err := tx.Exec(`ALTER TABLE scrape_lock ADD COLUMN heartbeat timestamp`)
+		return err
+	})
+}
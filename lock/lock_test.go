@@ -0,0 +1,102 @@
+package lock
+
+import (
+	"database/sql"
+	"github.com/strider-/dreamer/storage"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// A lock table from before heartbeats, brought up to date
+func openStore(t *testing.T) storage.SQLStore {
+	dir, err := ioutil.TempDir("", "lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	db, err := storage.OpenSQLite(filepath.Join(dir, "salty.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(db.Close)
+
+	err = storage.WithTrans(db, func(tx *sql.Tx) error {
+		_, err := tx.Exec(`CREATE TABLE scrape_lock (name varchar(32) PRIMARY KEY, holder varchar(128) NOT NULL,
+			acquired timestamp NOT NULL)`)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := AddHeartbeat(db); err != nil {
+			t.Fatalf("adding the heartbeat, time %d: %v", i+1, err)
+		}
+	}
+	return db
+}
+
+func TestLock(t *testing.T) {
+	db := openStore(t)
+
+	acquire := func(holder string, timeout time.Duration) (locked bool) {
+		t.Helper()
+		err := storage.WithTrans(db, func(tx *sql.Tx) (e error) {
+			locked, e = Acquire(tx, holder, timeout)
+			return
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	beat := func(holder string) (held bool) {
+		t.Helper()
+		err := storage.WithTrans(db, func(tx *sql.Tx) (e error) {
+			held, e = Beat(tx, holder)
+			return
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	release := func(holder string) {
+		t.Helper()
+		if err := storage.WithTrans(db, func(tx *sql.Tx) error { return Release(tx, holder) }); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if !acquire("cron:1", time.Hour) {
+		t.Fatal("couldn't take a free lock")
+	}
+	if acquire("daemon:2", time.Hour) {
+		t.Fatal("took a lock someone else holds")
+	}
+	if !beat("cron:1") || beat("daemon:2") {
+		t.Error("heartbeats should only land for the holder")
+	}
+
+	// letting go of a lock you don't hold does nothing
+	release("daemon:2")
+	if acquire("daemon:2", time.Hour) {
+		t.Fatal("took the lock after someone else released it for the holder")
+	}
+
+	release("cron:1")
+	if !acquire("daemon:2", time.Hour) {
+		t.Fatal("couldn't take a released lock")
+	}
+
+	// a holder gone quiet for longer than the timeout has abandoned it
+	if !acquire("cron:3", -time.Hour) {
+		t.Fatal("couldn't take an abandoned lock")
+	}
+	if beat("daemon:2") {
+		t.Error("the old holder's heartbeat landed after the lock was taken over")
+	}
+}
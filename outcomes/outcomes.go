@@ -0,0 +1,81 @@
+/*
+How a match ended when it wasn't a straight win: draws, cancellations & matches still waiting on a
+winner. Only red & blue wins go in the matches table & move ratings; everything else is kept here.
+*/
+package outcomes
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+)
+
+const (
+	// only red & blue wins are decided & move ratings
+	RED        string = "red"
+	BLUE       string = "blue"
+	DRAW       string = "draw"
+	CANCELLED  string = "cancelled"
+	UNRESOLVED string = "unresolved"
+
+	CREATE_OUTCOMES_SQL string = `CREATE TABLE IF NOT EXISTS match_outcomes (
+		match_id integer PRIMARY KEY,
+		outcome varchar(16) NOT NULL,
+		winner_text varchar(255) NOT NULL,
+		updated timestamp NOT NULL)`
+)
+
+// What salty puts in the winner column for a match nobody won, lower cased
+var texts = map[string]string{
+	"draw":      DRAW,
+	"tie":       DRAW,
+	"tied":      DRAW,
+	"cancelled": CANCELLED,
+	"canceled":  CANCELLED,
+	"refund":    CANCELLED,
+	"refunded":  CANCELLED,
+}
+
+// Works out what a winner column that names neither fighter means. Only the whole text counts,
+// a fighter with "tie" in their name still isn't a draw.
+func Parse(winnerText string) string {
+	if outcome, ok := texts[strings.ToLower(strings.TrimSpace(winnerText))]; ok {
+		return outcome
+	}
+	return UNRESOLVED
+}
+
+// Whether the match had a winner, & so should count towards ratings
+func Decided(outcome string) bool {
+	return outcome == RED || outcome == BLUE
+}
+
+// Records (or updates) the outcome of a match that wasn't a straight win
+func Save(tx *sql.Tx, matchId int, outcome, winnerText string) error {
+	res, err := tx.Exec(`UPDATE match_outcomes SET outcome = $2, winner_text = $3, updated = $4 WHERE match_id = $1`,
+		matchId, outcome, winnerText, time.Now())
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		return nil
+	}
+	_, err = tx.Exec(`INSERT INTO match_outcomes (match_id, outcome, winner_text, updated) VALUES ($1, $2, $3, $4)`,
+		matchId, outcome, winnerText, time.Now())
+	return err
+}
+
+// Whether a stored match is still waiting on a winner
+func Unresolved(tx *sql.Tx, matchId int) (bool, error) {
+	var count int
+	err := tx.QueryRow(`SELECT COUNT(*) FROM match_outcomes WHERE match_id = $1 AND outcome = $2`,
+		matchId, UNRESOLVED).Scan(&count)
+	return count > 0, err
+}
+
+// Matches nobody won used to go in the matches table with a winner of 0; they're only kept as
+// outcomes now, so any still there are taken out
+func DropUndecided(tx *sql.Tx) error {
+	_, err := tx.Exec(`DELETE FROM matches WHERE winner = 0 AND match_id IN (SELECT match_id FROM match_outcomes)`)
+	return err
+}
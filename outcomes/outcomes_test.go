@@ -0,0 +1,128 @@
+package outcomes
+
+import (
+	"database/sql"
+	"github.com/strider-/dreamer/storage"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"spicerack"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	for text, want := range map[string]string{
+		"Draw":        DRAW,
+		" tie ":       DRAW,
+		"TIED":        DRAW,
+		"Cancelled":   CANCELLED,
+		"canceled":    CANCELLED,
+		"Refund":      CANCELLED,
+		"refunded":    CANCELLED,
+		"":            UNRESOLVED,
+		"Tie Fighter": UNRESOLVED,
+		"Draw Man":    UNRESOLVED,
+		"Ryu":         UNRESOLVED,
+	} {
+		if got := Parse(text); got != want {
+			t.Errorf("Parse(%q) = %q, want %q", text, got, want)
+		}
+	}
+}
+
+func TestDecided(t *testing.T) {
+	for _, outcome := range []string{DRAW, CANCELLED, UNRESOLVED, ""} {
+		if Decided(outcome) {
+			t.Errorf("%q counted as decided", outcome)
+		}
+	}
+	if !Decided(RED) || !Decided(BLUE) {
+		t.Error("a red or blue win didn't count as decided")
+	}
+}
+
+// Outcomes are saved, updated once a match is resolved & undecided matches cleared out of matches,
+// all against a real SQLite database
+func TestStored(t *testing.T) {
+	dir, err := ioutil.TempDir("", "outcomes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	db, err := storage.OpenSQLite(filepath.Join(dir, "salty.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	red, blue := &spicerack.Fighter{Name: "Ryu"}, &spicerack.Fighter{Name: "Ken"}
+	for _, f := range []*spicerack.Fighter{red, blue} {
+		if err := db.UpdateFighter(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now := time.Now()
+	// #2 is a draw from back when undecided matches went in matches with a winner of 0
+	for _, m := range []*spicerack.Match{
+		{MatchId: 1, RedId: red.Id, BlueId: blue.Id, Winner: int(spicerack.WINNER_RED), Created: now, Updated: now},
+		{MatchId: 2, RedId: red.Id, BlueId: blue.Id, Winner: 0, Created: now, Updated: now},
+	} {
+		if err := db.InsertMatch(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = storage.WithTrans(db, func(tx *sql.Tx) error {
+		if _, err := tx.Exec(CREATE_OUTCOMES_SQL); err != nil {
+			return err
+		}
+		if err := Save(tx, 2, DRAW, "Draw"); err != nil {
+			return err
+		}
+		if err := Save(tx, 3, UNRESOLVED, ""); err != nil {
+			return err
+		}
+		return DropUndecided(tx)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db.MatchExists(2) {
+		t.Error("the undecided match is still in matches")
+	}
+	if !db.MatchExists(1) {
+		t.Error("a decided match was dropped")
+	}
+
+	unresolved := func(matchId int) bool {
+		var is bool
+		err := storage.WithTrans(db, func(tx *sql.Tx) (e error) {
+			is, e = Unresolved(tx, matchId)
+			return
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return is
+	}
+	if !unresolved(3) || unresolved(2) || unresolved(4) {
+		t.Errorf("unresolved: #3 %v, #2 %v, #4 %v, want only #3", unresolved(3), unresolved(2), unresolved(4))
+	}
+
+	// resolving updates the row that's there
+	err = storage.WithTrans(db, func(tx *sql.Tx) error { return Save(tx, 3, CANCELLED, "Refund") })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unresolved(3) {
+		t.Error("#3 is still unresolved after being saved as cancelled")
+	}
+	var count int
+	storage.WithTrans(db, func(tx *sql.Tx) error {
+		return tx.QueryRow(`SELECT COUNT(*) FROM match_outcomes WHERE match_id = 3`).Scan(&count)
+	})
+	if count != 1 {
+		t.Errorf("#3 has %d outcome rows, want 1", count)
+	}
+}
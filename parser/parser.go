@@ -0,0 +1,313 @@
+/*
+Reading salty's stat & compendium pages. Every XPath the scraper relies on lives in a Profile, so
+markup changes on salty's end are a config change rather than a rebuild.
+*/
+package parser
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/moovweb/gokogiri"
+	ghtml "github.com/moovweb/gokogiri/html"
+	"github.com/moovweb/gokogiri/xml"
+	"github.com/strider-/dreamer/aliases"
+	"github.com/strider-/dreamer/archive"
+	"github.com/strider-/dreamer/outcomes"
+	"html"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"spicerack"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	FIRST_MATCHMAKING_MATCH int = 51966
+)
+
+var numRx = regexp.MustCompile(`[0-9]+`)
+
+// Row selectors are relative to a table row
+type Profile struct {
+	Version        string
+	RosterLinks    string
+	TableRows      string
+	NextPage       string
+	TournamentLink string
+	MatchUrl       string
+	RedName        string
+	RedBets        string
+	BlueName       string
+	BlueBets       string
+	Winner         string
+	Bettors        string
+	// optional; when salty shows when a match was played, & the time.Parse layout for it
+	MatchTime  string
+	TimeFormat string
+	// optional; the rows of a fighter's compendium page listing their author, life, meter etc,
+	// & where the name & value are in each row
+	Attributes     string
+	AttributeName  string
+	AttributeValue string
+}
+
+// The markup as of the last time someone looked
+var Default = &Profile{
+	Version:        "2014.1",
+	RosterLinks:    "//ul[@id='tierlist']/li/a",
+	TableRows:      "//table/tbody/tr",
+	NextPage:       "//div[@id='pagination']//a[text()='Next']",
+	TournamentLink: "td[1]/a/@href",
+	MatchUrl:       "td/a/@href",
+	RedName:        "td/a/span[@class='redtext']/text()",
+	RedBets:        "td/a/span[@class='redtext']/following-sibling::text()",
+	BlueName:       "td/a/span[@class='bluetext']/text()",
+	BlueBets:       "td/a/span[@class='bluetext']/following-sibling::text()",
+	Winner:         "td[position() = 2]/span/text()",
+	Bettors:        "td[last()]/text()",
+	Attributes:     "//div[@id='compendiumright']//table/tbody/tr",
+	AttributeName:  "td[1]/text()",
+	AttributeValue: "td[2]/text()",
+}
+
+// The bits of the config a profile can be read from; a *spicerack.Gofig
+type Config interface {
+	Map(section string) (map[string]interface{}, error)
+	Struct(section string, v interface{}) error
+}
+
+type Match struct {
+	Red, Blue, Winner          string
+	RedBets, BlueBets, Bettors int
+	MatchId                    int
+	FightWinner                spicerack.FightWinner
+	Outcome                    string
+	TournamentId, Page         int
+	Played                     time.Time
+}
+
+// Whether the match had a winner, & so should count towards ratings
+func (m *Match) Decided() bool {
+	return outcomes.Decided(m.Outcome)
+}
+
+type ByMatchId []*Match
+
+func (m ByMatchId) Len() int           { return len(m) }
+func (m ByMatchId) Swap(i, j int)      { m[i], m[j] = m[j], m[i] }
+func (m ByMatchId) Less(i, j int) bool { return m[i].MatchId < m[j].MatchId }
+
+// Starts from the default profile, overlaying the json file at path if given or the
+// config's parser_profile section if there is one
+func LoadProfile(conf Config, path string) (*Profile, error) {
+	p := *Default
+	if path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err = json.Unmarshal(data, &p); err != nil {
+			return nil, err
+		}
+	} else if section, _ := conf.Map("parser_profile"); len(section) > 0 {
+		if err := conf.Struct("parser_profile", &p); err != nil {
+			return nil, err
+		}
+	}
+
+	selectors := map[string]string{
+		"roster_links": p.RosterLinks, "table_rows": p.TableRows, "next_page": p.NextPage,
+		"tournament_link": p.TournamentLink, "match_url": p.MatchUrl, "red_name": p.RedName,
+		"red_bets": p.RedBets, "blue_name": p.BlueName, "blue_bets": p.BlueBets,
+		"winner": p.Winner, "bettors": p.Bettors}
+	for key, sel := range selectors {
+		if strings.TrimSpace(sel) == "" {
+			return nil, fmt.Errorf("parser profile %s is missing %s", p.Version, key)
+		}
+	}
+	if p.MatchTime != "" && p.TimeFormat == "" {
+		return nil, fmt.Errorf("parser profile %s has a match_time selector but no time_format to read it with", p.Version)
+	}
+	return &p, nil
+}
+
+// Splits matches sorted by id into runs from the same tournament page, so each page can be
+// imported on its own
+func SplitPages(matches []*Match) (pages [][]*Match) {
+	for i, m := range matches {
+		if i == 0 || m.Page != matches[i-1].Page {
+			pages = append(pages, nil)
+		}
+		pages[len(pages)-1] = append(pages[len(pages)-1], m)
+	}
+	return
+}
+
+// Parse a match row into a managed object, fighter names resolved through names (which can be nil)
+func (p *Profile) ParseMatch(n xml.Node, names *aliases.Table) (pm *Match, err error) {
+	pm = &Match{}
+	match_url, _ := n.Search(p.MatchUrl)
+	red, _ := n.Search(p.RedName)
+	redvalue, _ := n.Search(p.RedBets)
+	blue, _ := n.Search(p.BlueName)
+	bluevalue, _ := n.Search(p.BlueBets)
+	winner, _ := n.Search(p.Winner)
+	bettors, _ := n.Search(p.Bettors)
+
+	if len(match_url) > 0 {
+		pm.MatchId, _ = strconv.Atoi(numRx.FindString(match_url[0].String()))
+	}
+	if len(redvalue) > 0 {
+		pm.RedBets, _ = strconv.Atoi(numRx.FindString(redvalue[0].String()))
+	}
+	if len(bluevalue) > 0 {
+		pm.BlueBets, _ = strconv.Atoi(numRx.FindString(bluevalue[0].String()))
+	}
+
+	if len(red) > 0 {
+		pm.Red = names.Resolve(html.UnescapeString(red[0].String()))
+	}
+	if len(blue) > 0 {
+		pm.Blue = names.Resolve(html.UnescapeString(blue[0].String()))
+	}
+	if len(bettors) > 0 {
+		pm.Bettors, _ = strconv.Atoi(bettors[0].String())
+	}
+	if p.MatchTime != "" {
+		if played, _ := n.Search(p.MatchTime); len(played) > 0 {
+			pm.Played, _ = time.Parse(p.TimeFormat, strings.TrimSpace(played[0].String()))
+		}
+	}
+	pm.Outcome = outcomes.UNRESOLVED
+	if len(winner) > 0 {
+		pm.Winner = names.Resolve(html.UnescapeString(winner[0].String()))
+		pm.Outcome = outcomes.Parse(pm.Winner)
+		if pm.Winner == pm.Red {
+			pm.FightWinner = spicerack.WINNER_RED
+			pm.Outcome = outcomes.RED
+		} else if pm.Winner == pm.Blue {
+			pm.FightWinner = spicerack.WINNER_BLUE
+			pm.Outcome = outcomes.BLUE
+		}
+	}
+
+	if pm.MatchId == 0 {
+		err = errors.New("Unable to parse match id.")
+	} else if len(pm.Red) == 0 || len(pm.Blue) == 0 {
+		err = errors.New("Red or Blue fighter is an empty string.")
+	} else if pm.MatchId < FIRST_MATCHMAKING_MATCH {
+		err = errors.New("Pre-matchmaking fight. Ignored.")
+	}
+
+	return pm, err
+}
+
+// Pulls the tournament ids out of tournament stats rows
+func (p *Profile) ParseTournamentIds(rows []xml.Node) []int {
+	result := make([]int, 0, len(rows))
+	for _, r := range rows {
+		link, _ := r.Search(p.TournamentLink)
+		if len(link) == 0 {
+			continue
+		}
+		if id, err := strconv.Atoi(numRx.FindString(link[0].String())); err == nil {
+			result = append(result, id)
+		}
+	}
+	return result
+}
+
+// Pulls the name/value pairs off a compendium page. Names are lower cased without the trailing
+// colon, so "Author:" & "author" are the same attribute.
+func (p *Profile) ParseAttributes(doc *ghtml.HtmlDocument) map[string]string {
+	attrs := make(map[string]string)
+	rows, _ := doc.Search(p.Attributes)
+	for _, row := range rows {
+		name, _ := row.Search(p.AttributeName)
+		value, _ := row.Search(p.AttributeValue)
+		if len(name) == 0 || len(value) == 0 {
+			continue
+		}
+		key := strings.ToLower(strings.TrimSuffix(strings.TrimSpace(html.UnescapeString(name[0].String())), ":"))
+		if key != "" {
+			attrs[key] = strings.TrimSpace(html.UnescapeString(value[0].String()))
+		}
+	}
+	return attrs
+}
+
+// Runs the profile over every page in an archive, reporting to w what each selector found.
+// Returns false if any page comes up empty or any match row fails to parse.
+func (p *Profile) SelfTest(a *archive.Archive, w io.Writer) bool {
+	names, err := a.Names()
+	if err != nil {
+		fmt.Fprintf(w, "Failed to list archive: %v\n", err)
+		return false
+	}
+
+	ok := true
+	for _, name := range names {
+		page, err := a.LoadName(name)
+		if err != nil {
+			fmt.Fprintf(w, "FAIL %s: %v\n", name, err)
+			ok = false
+			continue
+		}
+		doc, err := gokogiri.ParseHtml(page)
+		if err != nil {
+			fmt.Fprintf(w, "FAIL %s: %v\n", name, err)
+			ok = false
+			continue
+		}
+
+		var result string
+		passed := true
+		switch {
+		case strings.HasPrefix(name, "compendium"):
+			rows, _ := doc.Search(p.RosterLinks)
+			result = fmt.Sprintf("%d fighters", len(rows))
+			passed = len(rows) > 0
+		case strings.HasPrefix(name, "stats_tournamentstats"):
+			rows, _ := doc.Search(p.TableRows)
+			ids := p.ParseTournamentIds(rows)
+			result = fmt.Sprintf("%d rows, %d tournament ids", len(rows), len(ids))
+			passed = len(rows) > 0 && len(ids) == len(rows)
+		case strings.HasPrefix(name, "stats_tournament_id"):
+			rows, _ := doc.Search(p.TableRows)
+			nextpage, _ := doc.Search(p.NextPage)
+			parsed, ignored, failed := 0, 0, 0
+			for _, r := range rows {
+				pm, err := p.ParseMatch(r, nil)
+				if err == nil {
+					parsed++
+				} else if pm.MatchId != 0 && pm.MatchId < FIRST_MATCHMAKING_MATCH {
+					ignored++
+				} else {
+					fmt.Fprintf(w, "  match #%d: %v\n", pm.MatchId, err)
+					failed++
+				}
+			}
+			result = fmt.Sprintf("%d rows, %d parsed, %d pre-matchmaking, %d failed, next page: %v",
+				len(rows), parsed, ignored, failed, len(nextpage) > 0)
+			passed = len(rows) > 0 && failed == 0
+		default:
+			result = "skipped, unknown page"
+		}
+		if passed {
+			fmt.Fprintf(w, "ok   %s: %s\n", name, result)
+		} else {
+			fmt.Fprintf(w, "FAIL %s: %s\n", name, result)
+			ok = false
+		}
+	}
+
+	if ok {
+		fmt.Fprintf(w, "Parser profile %s passed against %d pages\n", p.Version, len(names))
+	} else {
+		fmt.Fprintf(w, "Parser profile %s FAILED\n", p.Version)
+	}
+	return ok
+}
@@ -0,0 +1,139 @@
+package parser
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// A config with an optional parser_profile section, read the way gofig reads one
+type fakeConfig map[string]interface{}
+
+func (c fakeConfig) Map(section string) (map[string]interface{}, error) {
+	if section != "parser_profile" || c == nil {
+		return nil, errors.New("no such section")
+	}
+	return c, nil
+}
+
+func (c fakeConfig) Struct(section string, v interface{}) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func TestLoadProfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "parser")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := func(json string) string {
+		path := filepath.Join(dir, "profile.json")
+		if err := ioutil.WriteFile(path, []byte(json), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	tests := []struct {
+		name    string
+		conf    fakeConfig
+		json    string
+		version string
+		check   func(p *Profile) bool
+		err     string
+	}{
+		{name: "nothing set", version: Default.Version,
+			check: func(p *Profile) bool { return *p == *Default }},
+		{name: "config section", conf: fakeConfig{"Version": "2015.2", "Winner": "td[3]/text()"}, version: "2015.2",
+			check: func(p *Profile) bool { return p.Winner == "td[3]/text()" && p.TableRows == Default.TableRows }},
+		// a file wins over the config
+		{name: "json file", conf: fakeConfig{"Version": "2015.2"}, json: `{"Version": "2016.1", "Bettors": "td[4]/text()"}`,
+			version: "2016.1",
+			check:   func(p *Profile) bool { return p.Bettors == "td[4]/text()" && p.RedName == Default.RedName }},
+		{name: "match time", json: `{"MatchTime": "td[5]/text()", "TimeFormat": "2006-01-02 15:04"}`, version: Default.Version,
+			check: func(p *Profile) bool { return p.MatchTime == "td[5]/text()" }},
+		{name: "blanked selector", conf: fakeConfig{"NextPage": " "}, err: "missing next_page"},
+		{name: "match time without a format", json: `{"MatchTime": "td[5]/text()"}`, err: "no time_format"},
+		{name: "broken json", json: `{"Version": `, err: "unexpected end"},
+	}
+	for _, tt := range tests {
+		path := ""
+		if tt.json != "" {
+			path = file(tt.json)
+		}
+		p, err := LoadProfile(tt.conf, path)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: got %v, want an error about %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if p.Version != tt.version || !tt.check(p) {
+			t.Errorf("%s: loaded %+v", tt.name, *p)
+		}
+	}
+
+	if Default.Version != "2014.1" || Default.Winner != "td[position() = 2]/span/text()" {
+		t.Error("loading a profile changed the default")
+	}
+	if _, err := LoadProfile(nil, filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("loaded a profile file that isn't there")
+	}
+}
+
+func TestSplitPages(t *testing.T) {
+	match := func(id, page int) *Match { return &Match{MatchId: id, Page: page} }
+	tests := []struct {
+		matches []*Match
+		want    [][]int
+	}{
+		{nil, nil},
+		{[]*Match{match(1, 1)}, [][]int{{1}}},
+		{[]*Match{match(1, 1), match(2, 1), match(3, 2), match(4, 3), match(5, 3)}, [][]int{{1, 2}, {3}, {4, 5}}},
+		// a page coming back around is a new run, the order matches are in is what counts
+		{[]*Match{match(1, 1), match(2, 2), match(3, 1)}, [][]int{{1}, {2}, {3}}},
+	}
+	for _, tt := range tests {
+		pages := SplitPages(tt.matches)
+		var got [][]int
+		for _, page := range pages {
+			ids := []int{}
+			for _, m := range page {
+				ids = append(ids, m.MatchId)
+			}
+			got = append(got, ids)
+		}
+		if !equal(got, tt.want) {
+			t.Errorf("SplitPages split %d matches into %v, want %v", len(tt.matches), got, tt.want)
+		}
+	}
+}
+
+func equal(a, b [][]int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if len(a[i]) != len(b[i]) {
+			return false
+		}
+		for j := range a[i] {
+			if a[i][j] != b[i][j] {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,77 @@
+/*
+Match rows that wouldn't parse, kept as raw html so they can be tried again once the parser
+profile's been fixed (the scraper's -reprocess-quarantine).
+*/
+package quarantine
+
+import (
+	"database/sql"
+	"time"
+)
+
+const (
+	CREATE_QUARANTINE_SQL string = `CREATE TABLE IF NOT EXISTS match_quarantine (
+		id serial PRIMARY KEY,
+		tournament_id integer NOT NULL,
+		page integer NOT NULL,
+		raw_html text NOT NULL,
+		error text NOT NULL,
+		attempts integer NOT NULL DEFAULT 1,
+		created timestamp NOT NULL,
+		updated timestamp NOT NULL)`
+)
+
+// A quarantined row & where it came from; Id & Attempts are only set on rows read back out
+type Row struct {
+	Id                 int
+	TournamentId, Page int
+	Html, Error        string
+	Attempts           int
+}
+
+// Quarantines a row, or bumps the attempt count & error of the same row from the same page if
+// it's already there
+func Add(tx *sql.Tx, r *Row) error {
+	res, err := tx.Exec(`UPDATE match_quarantine SET attempts = attempts + 1, error = $4, updated = $5
+		WHERE tournament_id = $1 AND page = $2 AND raw_html = $3`, r.TournamentId, r.Page, r.Html, r.Error, time.Now())
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		return nil
+	}
+	_, err = tx.Exec(`INSERT INTO match_quarantine (tournament_id, page, raw_html, error, created, updated)
+		VALUES ($1, $2, $3, $4, $5, $5)`, r.TournamentId, r.Page, r.Html, r.Error, time.Now())
+	return err
+}
+
+// Bumps the attempt count & error of a row that still fails after being reprocessed. By id, since
+// its html has been through the parser & back & mightn't match what was stored.
+func Bump(tx *sql.Tx, id int, reason string) error {
+	_, err := tx.Exec(`UPDATE match_quarantine SET attempts = attempts + 1, error = $2, updated = $3 WHERE id = $1`,
+		id, reason, time.Now())
+	return err
+}
+
+// Every quarantined row, oldest first
+func Load(tx *sql.Tx) (rows []*Row, err error) {
+	res, err := tx.Query(`SELECT id, tournament_id, page, raw_html, error, attempts FROM match_quarantine ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+	for res.Next() {
+		r := &Row{}
+		if err := res.Scan(&r.Id, &r.TournamentId, &r.Page, &r.Html, &r.Error, &r.Attempts); err != nil {
+			return nil, err
+		}
+		rows = append(rows, r)
+	}
+	return rows, res.Err()
+}
+
+// Lets a row go once it's parsed & been imported
+func Release(tx *sql.Tx, id int) error {
+	_, err := tx.Exec(`DELETE FROM match_quarantine WHERE id = $1`, id)
+	return err
+}
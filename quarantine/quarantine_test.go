@@ -0,0 +1,81 @@
+package quarantine
+
+import (
+	"database/sql"
+	"github.com/strider-/dreamer/storage"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const ROW_HTML = `<tr><td><a href="/stats?match_id=60000">Ryu vs ???</a></td></tr>`
+
+func TestQuarantine(t *testing.T) {
+	dir, err := ioutil.TempDir("", "quarantine")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	db, err := storage.OpenSQLite(filepath.Join(dir, "salty.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// runs each step in a transaction of its own, as the scraper does
+	step := func(fn func(tx *sql.Tx) error) {
+		t.Helper()
+		if err := storage.WithTrans(db, fn); err != nil {
+			t.Fatal(err)
+		}
+	}
+	load := func() (rows []*Row) {
+		t.Helper()
+		step(func(tx *sql.Tx) (e error) {
+			rows, e = Load(tx)
+			return
+		})
+		return
+	}
+
+	step(func(tx *sql.Tx) error {
+		_, err := tx.Exec(CREATE_QUARANTINE_SQL)
+		return err
+	})
+	if rows := load(); len(rows) != 0 {
+		t.Fatalf("a new table has %d rows", len(rows))
+	}
+
+	first := &Row{TournamentId: 101, Page: 2, Html: ROW_HTML, Error: "Red or Blue fighter is an empty string."}
+	step(func(tx *sql.Tx) error { return Add(tx, first) })
+	// the same row turning up on a later run only counts another attempt
+	step(func(tx *sql.Tx) error {
+		return Add(tx, &Row{TournamentId: 101, Page: 2, Html: ROW_HTML, Error: "Unable to parse match id."})
+	})
+	step(func(tx *sql.Tx) error { return Add(tx, &Row{TournamentId: 102, Page: 1, Html: ROW_HTML, Error: "x"}) })
+
+	rows := load()
+	if len(rows) != 2 {
+		t.Fatalf("%d rows quarantined, want 2", len(rows))
+	}
+	got := rows[0]
+	if got.Id == 0 || got.TournamentId != 101 || got.Page != 2 || got.Html != ROW_HTML ||
+		got.Attempts != 2 || got.Error != "Unable to parse match id." {
+		t.Errorf("first row read back as %+v, want 2 attempts & the latest error", *got)
+	}
+	if rows[1].TournamentId != 102 || rows[1].Attempts != 1 {
+		t.Errorf("second row read back as %+v", *rows[1])
+	}
+
+	step(func(tx *sql.Tx) error { return Bump(tx, rows[1].Id, "still broken") })
+	step(func(tx *sql.Tx) error { return Release(tx, rows[0].Id) })
+
+	rows = load()
+	if len(rows) != 1 || rows[0].TournamentId != 102 {
+		t.Fatalf("after releasing the first row, left with %v", rows)
+	}
+	if rows[0].Attempts != 2 || rows[0].Error != "still broken" {
+		t.Errorf("bumped row read back as %+v", *rows[0])
+	}
+}
@@ -0,0 +1,39 @@
+/*
+Messages for the IRC bot to post in its channels (those taking relays), sent to the HTTP endpoint it listens on.
+*/
+package relay
+
+import (
+	"fmt"
+	"github.com/strider-/dreamer/secrets"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+const (
+	PORT     int    = 4380
+	ENDPOINT string = "/shaker/bot/talk"
+)
+
+// Where the bot listens, which is this host
+func Addr() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s:%d", host, PORT)
+}
+
+// Hands msg to the bot to say in its channels, less any secrets the sender knows of
+func ToBot(msg string) error {
+	resp, err := http.PostForm(fmt.Sprintf("http://%s%s", Addr(), ENDPOINT), url.Values{"Message": {secrets.Redact(msg)}})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode != http.StatusOK:
+		return fmt.Errorf("bot answered with status %d", resp.StatusCode)
+	case resp.Header.Get("X-Success") == "false":
+		return fmt.Errorf("bot turned the message down: %s", resp.Header.Get("X-Error"))
+	}
+	return nil
+}
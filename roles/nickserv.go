@@ -0,0 +1,167 @@
+package roles
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// how NickServ is asked about a nick; ACC for atheme, STATUS for anope
+	CHECK_ACC    string = "acc"
+	CHECK_STATUS string = "status"
+	// NickServ's status for a nick that's identified
+	IDENTIFIED string = "3"
+	// where NickServ's notices come from, unless a network says otherwise; atheme & anope both
+	// default to a services. host. Anyone else could call themselves NickServ on a network without it.
+	DEFAULT_SERVICES string = "NickServ!*@services.*"
+)
+
+// Asks NickServ which account a nick is logged in as, & remembers the answer for a while. The bot
+// only hears about nick changes & quits in channels it shares, so a remembered answer is only good
+// for things it doesn't matter much to get wrong.
+type Verifier struct {
+	// how long an answer is good for
+	TTL time.Duration
+	// how long to wait for NickServ before deciding the nick isn't identified
+	Timeout time.Duration
+
+	ask     func(network, msg string)
+	mu      sync.Mutex
+	known   map[string]answer
+	waiting map[string]*question
+}
+
+type answer struct {
+	account string
+	at      time.Time
+}
+
+// Everyone waiting on the answer to one question
+type question struct {
+	then []func(account string)
+}
+
+// A verifier sending its questions to NickServ on a network through ask
+func NewVerifier(ask func(network, msg string)) *Verifier {
+	return &Verifier{
+		TTL:     2 * time.Minute,
+		Timeout: 10 * time.Second,
+		ask:     ask,
+		known:   make(map[string]answer),
+		waiting: make(map[string]*question),
+	}
+}
+
+// Calls then with the account nick is logged in as on network, or "" if they aren't. NickServ is
+// asked (the way check says, ACC or STATUS) unless it's answered recently & fresh isn't set, in
+// which case then is called straight away; otherwise it's called once NickServ answers or the wait
+// times out.
+func (v *Verifier) Account(network, nick, check string, fresh bool, then func(account string)) {
+	key := v.key(network, nick)
+	v.mu.Lock()
+	if a, ok := v.known[key]; ok && !fresh && time.Since(a.at) < v.TTL {
+		v.mu.Unlock()
+		then(a.account)
+		return
+	}
+	q, asked := v.waiting[key]
+	if !asked {
+		q = &question{}
+		v.waiting[key] = q
+	}
+	q.then = append(q.then, then)
+	v.mu.Unlock()
+	if asked {
+		return
+	}
+
+	if check == CHECK_STATUS {
+		v.ask(network, fmt.Sprintf("STATUS %s", nick))
+	} else {
+		v.ask(network, fmt.Sprintf("ACC %s *", nick))
+	}
+	time.AfterFunc(v.Timeout, func() { v.resolve(key, q, "", false) })
+}
+
+// The account nick is logged in as on network, if NickServ's said so recently; never asks
+func (v *Verifier) Known(network, nick string) (string, bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	a, ok := v.known[v.key(network, nick)]
+	if !ok || time.Since(a.at) >= v.TTL {
+		return "", false
+	}
+	return a.account, true
+}
+
+// Takes a notice from NickServ on network, returning whether it answered a question. Only give it
+// notices from the network's services mask, anything passed in is believed. Understands
+// atheme's "nick -> account ACC 3" (or just "nick ACC 3") & anope's "STATUS nick 3 account".
+func (v *Verifier) Answer(network, notice string) bool {
+	fields := strings.Fields(notice)
+	var nick, account, status string
+	switch {
+	case len(fields) >= 3 && fields[0] == "STATUS":
+		nick, status, account = fields[1], fields[2], fields[1]
+		if len(fields) > 3 {
+			account = fields[3]
+		}
+	case len(fields) >= 3 && fields[len(fields)-2] == "ACC":
+		nick, status, account = fields[0], fields[len(fields)-1], fields[0]
+		if len(fields) >= 5 && fields[1] == "->" {
+			account = fields[2]
+		}
+	default:
+		return false
+	}
+	if status != IDENTIFIED {
+		account = ""
+	}
+	return v.resolve(v.key(network, nick), nil, account, true)
+}
+
+// Forgets what NickServ said about nick, after they change nick, quit or the like
+func (v *Verifier) Forget(network, nick string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	delete(v.known, v.key(network, nick))
+}
+
+// Forgets everything NickServ said on network, for when the bot reconnects & can't know who
+// came & went while it was away
+func (v *Verifier) Reset(network string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for key := range v.known {
+		if strings.HasPrefix(key, network+" ") {
+			delete(v.known, key)
+		}
+	}
+}
+
+// Hands account to everyone waiting on key, remembering it if NickServ said so. A timed out
+// question only resolves itself, not one asked since. Returns whether anyone was waiting.
+func (v *Verifier) resolve(key string, only *question, account string, answered bool) bool {
+	v.mu.Lock()
+	q, ok := v.waiting[key]
+	if !ok || (only != nil && q != only) {
+		v.mu.Unlock()
+		return false
+	}
+	delete(v.waiting, key)
+	if answered {
+		v.known[key] = answer{account, time.Now()}
+	}
+	v.mu.Unlock()
+
+	for _, then := range q.then {
+		then(account)
+	}
+	return true
+}
+
+func (v *Verifier) key(network, nick string) string {
+	return network + " " + strings.ToLower(nick)
+}
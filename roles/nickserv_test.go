@@ -0,0 +1,167 @@
+package roles
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// A verifier whose questions end up in asked as "network: msg"
+func testVerifier(asked *[]string) *Verifier {
+	return NewVerifier(func(network, msg string) { *asked = append(*asked, network+": "+msg) })
+}
+
+func TestVerifierAnswer(t *testing.T) {
+	tests := []struct {
+		check, nick, notice string
+		answered            bool
+		account             string
+	}{
+		// atheme
+		{CHECK_ACC, "joe", "joe ACC 3", true, "joe"},
+		{CHECK_ACC, "joe", "JOE ACC 3", true, "JOE"},
+		{CHECK_ACC, "joe", "joe -> JoeAccount ACC 3", true, "JoeAccount"},
+		{CHECK_ACC, "joe", "joe -> JoeAccount ACC 2", true, ""},
+		{CHECK_ACC, "joe", "joe ACC 1", true, ""},
+		{CHECK_ACC, "joe", "joe ACC 0", true, ""},
+		// anope
+		{CHECK_STATUS, "joe", "STATUS joe 3", true, "joe"},
+		{CHECK_STATUS, "joe", "STATUS joe 3 JoeAccount", true, "JoeAccount"},
+		{CHECK_STATUS, "joe", "STATUS Joe 3 JoeAccount", true, "JoeAccount"},
+		{CHECK_STATUS, "joe", "STATUS joe 1", true, ""},
+		{CHECK_STATUS, "joe", "STATUS joe 0", true, ""},
+		// about someone else, or nothing to do with it
+		{CHECK_ACC, "joe", "ann ACC 3", false, ""},
+		{CHECK_STATUS, "joe", "STATUS ann 3 joe", false, ""},
+		{CHECK_ACC, "joe", "You are now identified for joe.", false, ""},
+		{CHECK_ACC, "joe", "joe ACC", false, ""},
+		{CHECK_ACC, "joe", "", false, ""},
+	}
+	for _, tt := range tests {
+		var asked []string
+		v := testVerifier(&asked)
+		var got []string
+		v.Account("rizon", "joe", tt.check, false, func(account string) { got = append(got, account) })
+
+		if answered := v.Answer("rizon", tt.notice); answered != tt.answered {
+			t.Errorf("Answer(%q) = %v, want %v", tt.notice, answered, tt.answered)
+		}
+		var want []string
+		if tt.answered {
+			want = []string{tt.account}
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Answer(%q) gave %q, want %q", tt.notice, got, want)
+		}
+	}
+}
+
+func TestVerifierAsks(t *testing.T) {
+	tests := []struct {
+		check, want string
+	}{
+		{CHECK_ACC, "rizon: ACC joe *"},
+		{CHECK_STATUS, "rizon: STATUS joe"},
+		{"", "rizon: ACC joe *"},
+	}
+	for _, tt := range tests {
+		var asked []string
+		testVerifier(&asked).Account("rizon", "joe", tt.check, false, func(string) {})
+		if !reflect.DeepEqual(asked, []string{tt.want}) {
+			t.Errorf("asked %q for a %q check, want %q", asked, tt.check, tt.want)
+		}
+	}
+}
+
+func TestVerifierRemembers(t *testing.T) {
+	var asked []string
+	v := testVerifier(&asked)
+	var got []string
+	then := func(account string) { got = append(got, account) }
+
+	// asking twice before the answer only asks NickServ once
+	v.Account("rizon", "joe", CHECK_ACC, false, then)
+	v.Account("rizon", "Joe", CHECK_ACC, false, then)
+	v.Answer("rizon", "joe ACC 3")
+	v.Account("rizon", "joe", CHECK_ACC, false, then)
+	if len(asked) != 1 || !reflect.DeepEqual(got, []string{"joe", "joe", "joe"}) {
+		t.Fatalf("asked %q & got %q, want one question & three answers", asked, got)
+	}
+
+	steps := []struct {
+		what   string
+		before func()
+		fresh  bool
+		asks   bool
+	}{
+		{"remembered", func() {}, false, false},
+		{"fresh", func() {}, true, true},
+		{"forgotten", func() { v.Forget("rizon", "JOE") }, false, true},
+		{"another network reset", func() { v.Reset("esper") }, false, false},
+		{"reset", func() { v.Reset("rizon") }, false, true},
+		{"out of date", func() { v.TTL = 0 }, false, true},
+	}
+	for _, s := range steps {
+		asked, got = nil, nil
+		s.before()
+		v.Account("rizon", "joe", CHECK_ACC, s.fresh, then)
+		if asks := len(asked) > 0; asks != s.asks {
+			t.Errorf("%s: asked %q, want a question %v", s.what, asked, s.asks)
+		}
+		v.Answer("rizon", "joe ACC 3")
+		if !reflect.DeepEqual(got, []string{"joe"}) {
+			t.Errorf("%s: got %q, want one answer", s.what, got)
+		}
+	}
+}
+
+func TestVerifierKnown(t *testing.T) {
+	var asked []string
+	v := testVerifier(&asked)
+	if _, ok := v.Known("rizon", "joe"); ok {
+		t.Error("known before NickServ was asked")
+	}
+	v.Account("rizon", "joe", CHECK_ACC, false, func(string) {})
+	if _, ok := v.Known("rizon", "joe"); ok {
+		t.Error("known before NickServ answered")
+	}
+	v.Answer("rizon", "joe -> JoeAccount ACC 3")
+	if account, ok := v.Known("rizon", "JOE"); !ok || account != "JoeAccount" {
+		t.Errorf("Known = %q, %v, want JoeAccount", account, ok)
+	}
+	if _, ok := v.Known("esper", "joe"); ok {
+		t.Error("known on another network")
+	}
+	v.TTL = 0
+	if _, ok := v.Known("rizon", "joe"); ok {
+		t.Error("known after the answer went out of date")
+	}
+	if len(asked) != 1 {
+		t.Errorf("asked %q, want one question", asked)
+	}
+}
+
+func TestVerifierTimeout(t *testing.T) {
+	var asked []string
+	v := testVerifier(&asked)
+	v.Timeout = time.Millisecond
+	got := make(chan string, 1)
+	v.Account("rizon", "joe", CHECK_ACC, false, func(account string) { got <- account })
+	select {
+	case account := <-got:
+		if account != "" {
+			t.Errorf("got %q after no answer, want no account", account)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("nothing after the timeout")
+	}
+
+	// a late answer isn't taken, & not hearing back isn't remembered
+	if v.Answer("rizon", "joe ACC 3") {
+		t.Error("a late answer was taken")
+	}
+	v.Account("rizon", "joe", CHECK_ACC, false, func(string) {})
+	if len(asked) != 2 {
+		t.Errorf("asked %q, want the question asked again", asked)
+	}
+}
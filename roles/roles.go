@@ -0,0 +1,141 @@
+/*
+Who the IRC bot's owner, admins & trusted users are, from the config's roles section:
+
+	"roles": {
+		"owner": ["account:Lone_Strider"],
+		"admin": ["account:some_admin", "*!*@admin.example.com"],
+		"trusted": ["*!*@*.example.org"]
+	}
+
+"account:NAME" matches whoever NickServ says is logged in as NAME, which can't be spoofed the way
+a nick can. Anything else is a nick!user@host mask, with * & ? wildcards. Everyone else is a user.
+*/
+package roles
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	ACCOUNT_PREFIX string = "account:"
+)
+
+type Role int
+
+const (
+	User Role = iota
+	Trusted
+	Admin
+	Owner
+)
+
+var names = map[Role]string{User: "user", Trusted: "trusted", Admin: "admin", Owner: "owner"}
+
+func (r Role) String() string {
+	return names[r]
+}
+
+// The role called name, if there is one
+func Parse(name string) (Role, bool) {
+	for r, n := range names {
+		if n == strings.ToLower(name) {
+			return r, true
+		}
+	}
+	return User, false
+}
+
+// Who has which role
+type Table struct {
+	accounts map[string]Role
+	masks    []mask
+}
+
+type mask struct {
+	rx   *regexp.Regexp
+	role Role
+}
+
+// Builds a table from the roles section, role name to who has it. Every problem is reported, not just the first.
+func NewTable(section map[string][]string) (*Table, []string) {
+	t := &Table{accounts: make(map[string]Role)}
+	var problems []string
+	for name, entries := range section {
+		role, ok := Parse(name)
+		if !ok || role == User {
+			problems = append(problems, fmt.Sprintf("roles.%s isn't a role, it should be owner, admin or trusted", name))
+			continue
+		}
+		for _, entry := range entries {
+			if err := t.Add(role, entry); err != nil {
+				problems = append(problems, fmt.Sprintf("roles.%s %v", name, err))
+			}
+		}
+	}
+	sort.Strings(problems)
+	return t, problems
+}
+
+// Gives role to an account ("account:NAME") or hostmask. Anyone matching more than one entry gets the highest.
+func (t *Table) Add(role Role, entry string) error {
+	if strings.HasPrefix(entry, ACCOUNT_PREFIX) {
+		account := strings.ToLower(strings.TrimPrefix(entry, ACCOUNT_PREFIX))
+		if account == "" {
+			return fmt.Errorf("has an account: without an account name")
+		}
+		if role > t.accounts[account] {
+			t.accounts[account] = role
+		}
+		return nil
+	}
+	if !IsMask(entry) {
+		return fmt.Errorf("has '%s', which should be account:NAME or a nick!user@host mask", entry)
+	}
+	t.masks = append(t.masks, mask{maskRx(entry), role})
+	return nil
+}
+
+// Whether s looks like a nick!user@host mask
+func IsMask(s string) bool {
+	return strings.Contains(s, "!") && strings.Contains(s, "@")
+}
+
+// Whether a nick!user@host matches a mask with * & ? wildcards, ignoring case
+func MatchMask(mask, hostmask string) bool {
+	return maskRx(mask).MatchString(strings.ToLower(hostmask))
+}
+
+func maskRx(mask string) *regexp.Regexp {
+	pattern := regexp.QuoteMeta(strings.ToLower(mask))
+	pattern = strings.Replace(pattern, `\*`, `.*`, -1)
+	pattern = strings.Replace(pattern, `\?`, `.`, -1)
+	return regexp.MustCompile("^" + pattern + "$")
+}
+
+// The role a nick!user@host has by its mask alone
+func (t *Table) ByMask(hostmask string) Role {
+	role := User
+	hostmask = strings.ToLower(hostmask)
+	for _, m := range t.masks {
+		if m.role > role && m.rx.MatchString(hostmask) {
+			role = m.role
+		}
+	}
+	return role
+}
+
+// The role a NickServ account has; a user for no account at all
+func (t *Table) ByAccount(account string) Role {
+	if account == "" {
+		return User
+	}
+	return t.accounts[strings.ToLower(account)]
+}
+
+// Whether anyone gets a role by account, & so whether asking NickServ could change anything
+func (t *Table) HasAccounts() bool {
+	return len(t.accounts) > 0
+}
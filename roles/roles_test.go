@@ -0,0 +1,137 @@
+package roles
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		want Role
+		ok   bool
+	}{
+		{"owner", Owner, true},
+		{"Admin", Admin, true},
+		{"TRUSTED", Trusted, true},
+		{"user", User, true},
+		{"mod", User, false},
+		{"", User, false},
+	}
+	for _, tt := range tests {
+		if got, ok := Parse(tt.name); got != tt.want || ok != tt.ok {
+			t.Errorf("Parse(%q) = %v, %v, want %v, %v", tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestNewTableProblems(t *testing.T) {
+	tests := []struct {
+		section map[string][]string
+		want    []string
+	}{
+		{nil, nil},
+		{map[string][]string{"owner": {"account:Lone_Strider"}, "admin": {"*!*@admin.example.com"}}, nil},
+		{map[string][]string{"mod": {"account:someone"}, "user": {"account:someone"}}, []string{
+			"roles.mod isn't a role, it should be owner, admin or trusted",
+			"roles.user isn't a role, it should be owner, admin or trusted",
+		}},
+		{map[string][]string{"admin": {"account:", "Lone_Strider", "nick!user", "*!*@host"}}, []string{
+			"roles.admin has 'Lone_Strider', which should be account:NAME or a nick!user@host mask",
+			"roles.admin has 'nick!user', which should be account:NAME or a nick!user@host mask",
+			"roles.admin has an account: without an account name",
+		}},
+	}
+	for _, tt := range tests {
+		if _, problems := NewTable(tt.section); !reflect.DeepEqual(problems, tt.want) {
+			t.Errorf("NewTable(%v) problems = %q, want %q", tt.section, problems, tt.want)
+		}
+	}
+}
+
+func TestTableByMask(t *testing.T) {
+	table, problems := NewTable(map[string][]string{
+		"owner":   {"Lone_Strider!*@home.example.com"},
+		"admin":   {"*!*@admin.example.com", "*!op?@*"},
+		"trusted": {"*!*@*.example.org", "*!*@admin.example.com"},
+	})
+	if len(problems) > 0 {
+		t.Fatal(problems)
+	}
+	tests := []struct {
+		hostmask string
+		want     Role
+	}{
+		{"Lone_Strider!ls@home.example.com", Owner},
+		{"lone_strider!LS@HOME.EXAMPLE.COM", Owner},
+		{"Lone_Strider!ls@elsewhere.example.com", User},
+		// the highest role matched wins
+		{"someone!x@admin.example.com", Admin},
+		{"someone!op1@somewhere.net", Admin},
+		{"someone!op12@somewhere.net", User},
+		{"someone!x@a.b.example.org", Trusted},
+		{"someone!x@example.org", User},
+		// dots are dots, not any character
+		{"someone!x@adminxexample.com", User},
+		{"", User},
+	}
+	for _, tt := range tests {
+		if got := table.ByMask(tt.hostmask); got != tt.want {
+			t.Errorf("ByMask(%q) = %v, want %v", tt.hostmask, got, tt.want)
+		}
+	}
+}
+
+func TestMatchMask(t *testing.T) {
+	tests := []struct {
+		mask, hostmask string
+		want           bool
+	}{
+		{DEFAULT_SERVICES, "NickServ!NickServ@services.", true},
+		{DEFAULT_SERVICES, "NickServ!NickServ@services.esper.net", true},
+		{DEFAULT_SERVICES, "nickserv!nickserv@SERVICES.libera.chat", true},
+		{DEFAULT_SERVICES, "NickServ!~fake@some.isp.net", false},
+		{DEFAULT_SERVICES, "NickServ!NickServ@services", false},
+		{DEFAULT_SERVICES, "NickServ2!NickServ@services.", false},
+		{"NickServ!service@rizon.net", "NickServ!service@rizon.net", true},
+		{"NickServ!service@rizon.net", "NickServ!service@rizonxnet", false},
+		{"", "NickServ!NickServ@services.", false},
+	}
+	for _, tt := range tests {
+		if got := MatchMask(tt.mask, tt.hostmask); got != tt.want {
+			t.Errorf("MatchMask(%q, %q) = %v, want %v", tt.mask, tt.hostmask, got, tt.want)
+		}
+	}
+}
+
+func TestTableByAccount(t *testing.T) {
+	table, problems := NewTable(map[string][]string{
+		"owner":   {"account:Lone_Strider"},
+		"admin":   {"account:some_admin", "account:lone_strider"},
+		"trusted": {"account:some_admin", "*!*@*.example.org"},
+	})
+	if len(problems) > 0 {
+		t.Fatal(problems)
+	}
+	tests := []struct {
+		account string
+		want    Role
+	}{
+		{"Lone_Strider", Owner},
+		{"LONE_STRIDER", Owner},
+		{"some_admin", Admin},
+		{"someone", User},
+		{"", User},
+	}
+	for _, tt := range tests {
+		if got := table.ByAccount(tt.account); got != tt.want {
+			t.Errorf("ByAccount(%q) = %v, want %v", tt.account, got, tt.want)
+		}
+	}
+	if !table.HasAccounts() {
+		t.Error("HasAccounts() = false with accounts in the table")
+	}
+	if masks, _ := NewTable(map[string][]string{"admin": {"*!*@*.example.org"}}); masks.HasAccounts() {
+		t.Error("HasAccounts() = true with only masks in the table")
+	}
+}
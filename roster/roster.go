@@ -0,0 +1,120 @@
+/*
+Which fighters are still on salty's compendium tier list. Fighters missing from enough scrapes in
+a row are retired, & left out of fighter lists & counts. Written by the scraper, read by dreamer
+& the IRC bot.
+*/
+package roster
+
+import (
+	"database/sql"
+	"github.com/strider-/dreamer/storage"
+	"time"
+)
+
+const (
+	CREATE_STATUS_SQL string = `CREATE TABLE IF NOT EXISTS fighter_status (
+		fighter_id integer PRIMARY KEY,
+		last_seen timestamp,
+		missed integer NOT NULL DEFAULT 0,
+		retired timestamp)`
+)
+
+// Creates the status table if need be. Stores without SQL have nowhere to keep it, so nobody retires.
+func CreateTable(db storage.Store) error {
+	if _, ok := db.(storage.SQLStore); !ok {
+		return nil
+	}
+	return storage.WithTrans(db, func(tx *sql.Tx) error {
+		_, err := tx.Exec(CREATE_STATUS_SQL)
+		return err
+	})
+}
+
+// Marks a fighter as on the tier list as of scraped, bringing them out of retirement if need be.
+// Returns whether they were retired.
+func Seen(tx *sql.Tx, fighterId int, scraped time.Time) (wasRetired bool, err error) {
+	err = tx.QueryRow(`SELECT retired IS NOT NULL FROM fighter_status WHERE fighter_id = $1`, fighterId).Scan(&wasRetired)
+	switch {
+	case err == sql.ErrNoRows:
+		_, err = tx.Exec(`INSERT INTO fighter_status (fighter_id, last_seen, missed) VALUES ($1, $2, 0)`, fighterId, scraped)
+		return false, err
+	case err != nil:
+		return false, err
+	}
+	_, err = tx.Exec(`UPDATE fighter_status SET last_seen = $2, missed = 0, retired = NULL WHERE fighter_id = $1`, fighterId, scraped)
+	return wasRetired, err
+}
+
+// Counts a missed scrape against every active fighter not seen as of scraped, retiring those who've
+// now missed threshold in a row. Returns the names of the newly retired.
+func Sweep(tx *sql.Tx, scraped time.Time, threshold int) (retired []string, err error) {
+	// fighters from before anyone was tracked start with a clean slate
+	_, err = tx.Exec(`INSERT INTO fighter_status (fighter_id, missed)
+		SELECT id, 0 FROM fighters WHERE id NOT IN (SELECT fighter_id FROM fighter_status)`)
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.Exec(`UPDATE fighter_status SET missed = missed + 1
+		WHERE retired IS NULL AND (last_seen IS NULL OR last_seen < $1)`, scraped)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query(`SELECT f.name FROM fighter_status s JOIN fighters f ON f.id = s.fighter_id
+		WHERE s.retired IS NULL AND s.missed >= $1 ORDER BY f.name`, threshold)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		retired = append(retired, name)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(`UPDATE fighter_status SET retired = $2 WHERE retired IS NULL AND missed >= $1`, threshold, scraped)
+	return retired, err
+}
+
+// Names of every retired fighter; none for stores without SQL
+func Retired(db storage.Store) (map[string]bool, error) {
+	names := make(map[string]bool)
+	if _, ok := db.(storage.SQLStore); !ok {
+		return names, nil
+	}
+	err := storage.WithTrans(db, func(tx *sql.Tx) error {
+		rows, err := tx.Query(`SELECT f.name FROM fighter_status s JOIN fighters f ON f.id = s.fighter_id
+			WHERE s.retired IS NOT NULL`)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var name string
+			if err := rows.Scan(&name); err != nil {
+				return err
+			}
+			names[name] = true
+		}
+		return rows.Err()
+	})
+	return names, err
+}
+
+// How many retired fighters never got a tier, to take off the untiered count
+func RetiredUntiered(db storage.Store) (count int, err error) {
+	if _, ok := db.(storage.SQLStore); !ok {
+		return 0, nil
+	}
+	err = storage.WithTrans(db, func(tx *sql.Tx) error {
+		return tx.QueryRow(`SELECT COUNT(*) FROM fighter_status s JOIN fighters f ON f.id = s.fighter_id
+			WHERE s.retired IS NOT NULL AND f.tier = 0`).Scan(&count)
+	})
+	return
+}
@@ -7,118 +7,517 @@ package main
 */
 
 import (
+	"database/sql"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"github.com/moovweb/gokogiri"
 	ghtml "github.com/moovweb/gokogiri/html"
 	"github.com/moovweb/gokogiri/xml"
+	"github.com/strider-/dreamer/aliases"
+	"github.com/strider-/dreamer/archive"
+	"github.com/strider-/dreamer/checkpoint"
+	"github.com/strider-/dreamer/compendium"
+	"github.com/strider-/dreamer/config"
+	"github.com/strider-/dreamer/dryrun"
+	"github.com/strider-/dreamer/fetch"
+	"github.com/strider-/dreamer/lock"
+	"github.com/strider-/dreamer/outcomes"
+	"github.com/strider-/dreamer/parser"
+	"github.com/strider-/dreamer/quarantine"
+	"github.com/strider-/dreamer/relay"
+	"github.com/strider-/dreamer/roster"
+	"github.com/strider-/dreamer/schedule"
+	"github.com/strider-/dreamer/secrets"
+	"github.com/strider-/dreamer/session"
+	"github.com/strider-/dreamer/storage"
 	"html"
 	"io/ioutil"
 	"net/http"
-	"net/url"
 	"os"
 	"regexp"
+	"sort"
 	"spicerack"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
-type Settings struct {
-	DbUser, DbPass, DbName string
-	IllumEmail, IllumPword string
-	RecentTournamentCount  int
+// Everything fetched for a single tournament by a worker, waiting to be written
+type ScrapedTournament struct {
+	Id, LastPage int
+	Matches      []*parser.Match
+	Rejects      []*quarantine.Row
+	Err          error
 }
 
-type ParsedMatch struct {
-	Red, Blue, Winner          string
-	RedBets, BlueBets, Bettors int
-	MatchId                    int
-	FightWinner                spicerack.FightWinner
+// A fighter on the compendium tier list & the link to their own compendium page
+type RosterEntry struct {
+	Fighter *spicerack.Fighter
+	Href    string
+}
+
+// How a single scrape went. Counters are bumped from the fetch workers too, so use atomic.
+type RunSummary struct {
+	Trigger         string
+	Status          string
+	Error           string `json:",omitempty"`
+	Started         time.Time
+	Finished        time.Time
+	Duration        string
+	PagesFetched    int64
+	PagesFailed     int64
+	MatchesNew      int64
+	MatchesResolved int64
+	MatchesSkipped  int64
+	MatchesErrored  int64
+	FightersAdded   int64
+	FightersUpdated int64
+	DetailsChanged  int64
+	FightersRetired int64
+	Retired         []string `json:",omitempty"`
 }
 
 var (
-	repo         *spicerack.Repository
-	numRx        *regexp.Regexp
+	repo        storage.SQLStore
+	numRx       *regexp.Regexp
+	throttle    *fetch.Throttle
+	pageArchive *archive.Archive
+	report      *dryrun.Report
+	summary     = &RunSummary{}
+	sessions    *session.Manager
+	// everything the scraper prints goes through here, so no secret makes it into the logs
+	out          = secrets.NewWriter(os.Stdout)
+	names        *aliases.Table
+	profile      = parser.Default
+	retriesLeft  int64
 	resetElo     = flag.Bool("reset-elo", false, "Recalcuates elo values")
 	eloBase      = flag.Int("elo-base", 300, "Provides a base elo value")
 	saltTheEarth = flag.Bool("salt-the-earth", false, "Complete teardown and rebuild.")
+	fresh        = flag.Bool("fresh", false, "Ignores any saved checkpoint and starts from the first tournament")
+	minTourney   = flag.Int("min-tournament", FIRST_MATCHMAKING_TOURNAMENT, "Lowest tournament id a full rebuild will scrape")
+	maxTourney   = flag.Int("max-tournament", 0, "Highest tournament id a full rebuild will scrape (0 for no limit)")
+	rediscover   = flag.Bool("rediscover", false, "Walks every tournament stats page instead of stopping at known tournaments")
+	workers      = flag.Int("workers", 4, "Number of tournaments fetched concurrently")
+	requestRate  = flag.Float64("rate", 2, "Maximum page requests per second across all workers")
+	jitter       = flag.Duration("jitter", 500*time.Millisecond, "Maximum random delay added before each page request")
+	hostCap      = flag.Int("host-concurrency", 2, "Maximum in-flight page requests per host")
+	fetchTimeout = flag.Duration("timeout", 30*time.Second, "Timeout for a single page request")
+	maxRetries   = flag.Int("retries", 3, "Times a single page request is retried before giving up")
+	retryBudget  = flag.Int64("retry-budget", 50, "Total page retries allowed for the whole run")
+	pageAttempts = flag.Int("page-attempts", 5, "Runs a failed tournament page is tried on before the rest of its tournament is skipped, leaving the page in scrape_failed_pages for someone to look at")
+	recordTo     = flag.String("record", "", "Archives every fetched page to this directory (or .tar.gz)")
+	replayFrom   = flag.String("replay", "", "Scrapes pages from this archive directory (or .tar.gz) instead of saltybet")
+	dryRun       = flag.Bool("dry-run", false, "Scrapes tournaments & the roster but changes nothing, schema included, printing a report of what would change")
+	reportFormat = flag.String("report-format", "text", "Dry-run report format, text or json")
+	profilePath  = flag.String("profile", "", "Loads the parser profile from this json file instead of the config")
+	selfTest     = flag.String("self-test", "", "Validates the parser profile against an archive directory (or .tar.gz) & exits")
+	daemon       = flag.Bool("daemon", false, "Stays running, scraping on a schedule instead of once")
+	every        = flag.Duration("every", time.Hour, "Daemon mode: time between scrapes, if -cron isn't given")
+	cronExpr     = flag.String("cron", "", "Daemon mode: cron-like schedule, e.g. \"15 */2 * * *\"")
+	listenAddr   = flag.String("listen", "", "Daemon mode: address for the HTTP scrape trigger, e.g. localhost:4381")
+	lockTimeout  = flag.Duration("lock-timeout", 10*time.Minute, "Time without a heartbeat after which another run's scrape lock is considered abandoned")
+	summaryPath  = flag.String("summary", "", "Writes each run's summary as json to this file")
+	addAlias     = flag.String("alias", "", "Adds a fighter alias as \"alias=canonical name\" & exits")
+	removeAlias  = flag.String("unalias", "", "Removes a fighter alias & exits")
+	listAliases  = flag.Bool("aliases", false, "Lists every fighter alias & exits")
+	mergeFighter = flag.String("merge", "", "Merges one fighter into another as \"from=into\", leaving an alias behind, & exits")
+	reprocess    = flag.Bool("reprocess-quarantine", false, "Re-parses quarantined match rows with the current parser profile & exits")
+	details      = flag.Bool("details", true, "Scrapes new fighters' compendium pages (& stale ones) for their author, life, meter & other attributes")
+	detailsAge   = flag.Duration("details-age", 7*24*time.Hour, "How long a fighter's compendium details go before their page is scraped again")
+	retireAfter  = flag.Int("retire-after", 3, "Consecutive compendium scrapes a fighter can be missing from before they're retired")
+
+	ErrScrapeRunning = errors.New("another scrape is already running")
+	// a fetch given up on because an earlier tournament failed
+	errStopped = errors.New("stopped after an earlier page failed")
+)
+
+const (
+	// where a match's played time came from, best to worst
+	TIME_SITE      string = "site"
+	TIME_SCRAPED   string = "scraped"
+	TIME_ESTIMATED string = "estimated"
+
+	// exit codes
+	EXIT_OK      int = 0
+	EXIT_FAILED  int = 1
+	EXIT_PARTIAL int = 2
+
+	TRIGGER_ENDPOINT string = "/scraper/run"
+	// the checkpoint saved once the tournament stats pages have been walked to the end
+	DISCOVERY_MODE  string = "discovery"
+	LOG_TIME_FORMAT string = "2006-01-02 15:04:05"
+
+	// Rough estimate on first matchmaking fight: Snake Eyes vs Namor; tournament #101, match #51966
+	FIRST_MATCHMAKING_TOURNAMENT int = 101
+
+	CREATE_FAILED_PAGES_SQL string = `CREATE TABLE IF NOT EXISTS scrape_failed_pages (
+		tournament_id integer NOT NULL,
+		page integer NOT NULL,
+		error text NOT NULL,
+		attempts integer NOT NULL DEFAULT 1,
+		updated timestamp NOT NULL,
+		PRIMARY KEY (tournament_id, page))`
+	CREATE_TOURNAMENTS_SQL string = `CREATE TABLE IF NOT EXISTS scrape_tournaments (
+		tournament_id integer PRIMARY KEY,
+		discovered timestamp NOT NULL)`
+	CREATE_MATCH_DETAILS_SQL string = `CREATE TABLE IF NOT EXISTS match_details (
+		match_id integer PRIMARY KEY,
+		tournament_id integer NOT NULL,
+		played timestamp,
+		time_source varchar(16),
+		red_tier integer,
+		blue_tier integer)`
+	CREATE_SNAPSHOTS_SQL string = `CREATE TABLE IF NOT EXISTS rating_snapshots (
+		match_id integer NOT NULL,
+		fighter_id integer NOT NULL,
+		elo_before integer NOT NULL,
+		elo_after integer NOT NULL,
+		total_bets integer NOT NULL,
+		created timestamp NOT NULL,
+		PRIMARY KEY (match_id, fighter_id))`
+	CREATE_RUNS_SQL string = `CREATE TABLE IF NOT EXISTS scrape_runs (
+		id serial PRIMARY KEY,
+		trigger varchar(32) NOT NULL,
+		host varchar(128) NOT NULL,
+		started timestamp NOT NULL,
+		finished timestamp,
+		succeeded boolean,
+		error text)`
 )
 
 func main() {
+	os.Exit(run())
+}
+
+// Everything main does, returning the exit code rather than exiting so deferred closes (the
+// database, a -record tarball) always run
+func run() int {
 	// load the config file
 	flag.Parse()
-	conf, err := spicerack.GofigFromEnv("ME_CONF")
-	if err != nil {
-		fmt.Printf("%v\nQuitting.\n", err)
-		os.Exit(1)
+	if config.IsCheckCommand(flag.Args()) {
+		if !config.Check(out, config.Scraper) {
+			return EXIT_FAILED
+		}
+		return EXIT_OK
+	}
+	// problems with the salty section can wait, a self-test only needs the parser profile
+	settings, confErr := config.Load(config.Scraper)
+	if settings == nil {
+		fmt.Fprintf(out, "%v\nQuitting.\n", confErr)
+		return EXIT_FAILED
 	}
 
-	// inflate settings struct & open db connection
-	settings := &Settings{}
-	conf.Struct("salty", settings)
-	repo, err = spicerack.OpenDb(settings.DbUser, settings.DbPass, settings.DbName)
+	// compile a number regex, we'll be using it a lot in parsing
+	numRx, _ = regexp.Compile(`[0-9]+`)
+
+	// load the parser profile, & check it against an archive if that's all we're here for
+	var err error
+	if profile, err = parser.LoadProfile(settings.Raw, *profilePath); err != nil {
+		fmt.Fprintf(out, "Failed to load parser profile: %v\n", err)
+		return EXIT_FAILED
+	}
+	fmt.Fprintf(out, "Using parser profile %s\n", profile.Version)
+	if *selfTest != "" {
+		a, err := archive.Replay(*selfTest)
+		if err != nil {
+			fmt.Fprintf(out, "Failed to open archive: %v\n", err)
+			return EXIT_FAILED
+		}
+		defer a.Close()
+		if !profile.SelfTest(a, out) {
+			return EXIT_FAILED
+		}
+		return EXIT_OK
+	}
+
+	// everything else needs a usable config & a db connection
+	for _, warning := range settings.Warnings {
+		fmt.Fprintf(out, "Warning: %s\n", warning)
+	}
+	if confErr != nil {
+		fmt.Fprintf(out, "%v\nQuitting.\n", confErr)
+		return EXIT_FAILED
+	}
+	repo, err = storage.OpenSQL(settings.DbBackend, settings.DbUser, settings.DbPass, settings.DbName)
 	if err != nil {
-		fmt.Printf("Failed to connect to postgres: %v\n", err)
-		os.Exit(1)
+		fmt.Fprintf(out, "Failed to open storage: %v\n", err)
+		return EXIT_FAILED
 	}
 	defer repo.Close()
 
 	// reset ELO values if options are present
-	if *resetElo {
+	if *dryRun {
+		if *resetElo {
+			fmt.Fprintln(out, "-reset-elo can't be used with -dry-run.")
+			return EXIT_FAILED
+		}
+		report = dryrun.New()
+	} else if *resetElo {
 		repo.ResetElo(*eloBase)
 	}
 
-	// log into saltybet
-	client, err := spicerack.LogIntoSaltyBet(settings.IllumEmail, settings.IllumPword)
+	// open the page archive if we're recording or replaying
+	if *recordTo != "" && *replayFrom != "" {
+		fmt.Fprintln(out, "-record and -replay can't be used together.")
+		return EXIT_FAILED
+	} else if *recordTo != "" {
+		pageArchive, err = archive.Record(*recordTo)
+	} else if *replayFrom != "" {
+		pageArchive, err = archive.Replay(*replayFrom)
+	}
 	if err != nil {
-		fmt.Printf("Error logging into saltybet: %v\n", err)
-		os.Exit(1)
+		fmt.Fprintf(out, "Failed to open page archive: %v\n", err)
+		return EXIT_FAILED
+	}
+	if pageArchive != nil {
+		defer pageArchive.Close()
 	}
 
-	// compile a number regex, we'll be using it a lot in parsing
-	numRx, _ = regexp.Compile(`[0-9]+`)
+	throttle = fetch.NewThrottle(*requestRate, *jitter, *hostCap)
+
+	// make sure we have somewhere to keep checkpoints, failed pages, run history, aliases & fighter
+	// details. A dry run leaves the schema alone too, so it can only run once they're all there.
+	if report != nil {
+		for _, step := range setupSteps {
+			report.SetupSkipped = append(report.SetupSkipped, step.Does)
+		}
+		if missing := missingTables(); len(missing) > 0 {
+			fmt.Fprintf(out, "-dry-run needs the tables a real run makes, missing: %s\n", strings.Join(missing, ", "))
+			return EXIT_FAILED
+		}
+	} else {
+		for _, step := range setupSteps {
+			if err := step.run(settings.DbBackend); err != nil {
+				fmt.Fprintf(out, "Failed to %s: %v\n", step.Does, err)
+				return EXIT_FAILED
+			}
+		}
+	}
+
+	// alias management is a one & done
+	if *addAlias != "" || *removeAlias != "" || *listAliases || *mergeFighter != "" {
+		if *dryRun && !*listAliases {
+			fmt.Fprintln(out, "-dry-run can't be used with -alias, -unalias or -merge.")
+			return EXIT_FAILED
+		}
+		if err := manageAliases(); err != nil {
+			fmt.Fprintf(out, "%v\nQuitting.\n", err)
+			return EXIT_FAILED
+		}
+		return EXIT_OK
+	}
+
+	if *daemon {
+		if *dryRun {
+			fmt.Fprintln(out, "-dry-run can't be used with -daemon.")
+			return EXIT_FAILED
+		}
+		sched, err := schedule.Parse(*every, *cronExpr)
+		if err != nil {
+			fmt.Fprintf(out, "Bad schedule: %v\n", err)
+			return EXIT_FAILED
+		}
+		runDaemon(settings, sched)
+		return EXIT_OK
+	}
+
+	job, trigger := func() error { return scrape(settings) }, "manual"
+	if *reprocess {
+		job, trigger = reprocessQuarantine, "quarantine"
+	}
+	if err := lockedRun(trigger, job); err != nil {
+		fmt.Fprintf(out, "%v\nQuitting.\n", err)
+		return EXIT_FAILED
+	}
+	if summary.Status == "partial" {
+		return EXIT_PARTIAL
+	}
+	return EXIT_OK
+}
+
+// Runs a scrape (or anything else that writes matches) under the scrape lock, recording it in the
+// run history. Returns ErrScrapeRunning without doing anything if another scrape holds the lock.
+// A dry run writes nothing, so it neither takes the lock nor goes in the history.
+func lockedRun(trigger string, run func() error) error {
+	var runId int
+	var err error
+	if !*dryRun {
+		holder := fmt.Sprintf("%s:%d", hostname(), os.Getpid())
+		var locked bool
+		err = runTrans(func(tx *sql.Tx) (e error) {
+			locked, e = lock.Acquire(tx, holder, *lockTimeout)
+			return
+		}, true)
+		if err != nil {
+			return err
+		} else if !locked {
+			return ErrScrapeRunning
+		}
+		stop := make(chan bool)
+		go heartbeat(holder, stop)
+		defer func() {
+			close(stop)
+			releaseScrapeLock(holder)
+		}()
+
+		if runId, err = startRun(trigger); err != nil {
+			fmt.Fprintf(out, "Failed to record scrape run: %v\n", err)
+		}
+	}
+	summary = &RunSummary{Trigger: trigger, Started: time.Now()}
+	err = run()
+	summary.Finish(err)
+	if fErr := finishRun(runId, err); fErr != nil {
+		fmt.Fprintf(out, "Failed to record scrape run: %v\n", fErr)
+	}
+
+	fmt.Fprintln(out, summary.String())
+	if *summaryPath != "" {
+		if wErr := summary.Write(*summaryPath); wErr != nil {
+			fmt.Fprintf(out, "Failed to write run summary: %v\n", wErr)
+		}
+	}
+	if report == nil {
+		relayToBot(summary.String())
+	}
+	return err
+}
+
+// One full scrape: the roster, then every tournament for this mode
+func scrape(settings *config.Config) error {
+	// log into saltybet, unless every page is coming from an archive. The session outlives
+	// a single run in daemon mode, & is checked on (& renewed) before each one.
+	var err error
+	client := &http.Client{Timeout: *fetchTimeout}
+	if *replayFrom == "" {
+		if sessions == nil {
+			sessions = session.NewManager(settings.IllumEmail, settings.IllumPword, *fetchTimeout)
+			sessions.Notify = relayToBot
+		}
+		if client, err = sessions.Client(); err != nil {
+			return fmt.Errorf("Error logging into saltybet: %v", err)
+		}
+	}
+	atomic.StoreInt64(&retriesLeft, *retryBudget)
+
+	if names, err = aliases.Load(repo); err != nil {
+		return fmt.Errorf("Failed to load fighter aliases: %v", err)
+	}
 
 	// scrape the compendium for updated/new characters
-	fmt.Println("Scraping Roster")
-	if err := getRoster(client); err != nil {
-		fmt.Printf("Failed to scrape roster: %v\n", err)
-		os.Exit(1)
+	fmt.Fprintln(out, "Scraping Roster")
+	entries, err := getRoster(client)
+	if err != nil {
+		return fmt.Errorf("Failed to scrape roster: %v", err)
+	}
+	retireMissing(entries)
+	if *details && profile.Attributes != "" {
+		fmt.Fprintln(out, "Scraping Fighter Details")
+		getFighterDetails(client, entries)
 	}
 
-	// Get the last n number of tournaments & scrape 'em
+	// Get the last n number of tournaments (or all of them) & scrape 'em
 	count := settings.RecentTournamentCount
-	fmt.Printf("Grabbing last %d tournament Ids\n", count)
 	var tourneys []int
 	if *saltTheEarth {
-		tourneys, _ = getAllTournamentIds()
+		fmt.Fprintln(out, "Discovering all tournament Ids")
+		tourneys, err = getAllTournamentIds(client, *minTourney, *maxTourney)
 	} else {
+		fmt.Fprintf(out, "Grabbing last %d tournament Ids\n", count)
 		tourneys, err = getLatestTournamentIds(client, count)
-		if err != nil {
-			fmt.Printf("Failed to grab tournament IDs: %v\n", err)
-			os.Exit(1)
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to grab tournament IDs: %v", err)
+	}
+
+	mode := "recent"
+	if *saltTheEarth {
+		mode = "salt-the-earth"
+	}
+	var cp *checkpoint.Checkpoint
+	if !*fresh {
+		if cp, err = loadCheckpoint(mode); err != nil {
+			fmt.Fprintf(out, "Failed to load checkpoint, starting from the top: %v\n", err)
+		}
+	}
+	// oldest first, so ratings are always built up in the same order
+	sort.Ints(tourneys)
+	tourneys, startPage := checkpoint.Resume(tourneys, cp)
+	if cp != nil && cp.HasNext {
+		fmt.Fprintf(out, "Resuming at Tournament #%d, Page #%d (last match #%d)\n", cp.TournamentId, cp.Page+1, cp.LastMatchId)
+	} else if cp != nil {
+		fmt.Fprintf(out, "Resuming after Tournament #%d (last match #%d)\n", cp.TournamentId, cp.LastMatchId)
+	}
+	if !scrapeTournaments(client, mode, tourneys, startPage, *workers) {
+		fmt.Fprintln(out, "Stopped at the first failed page, so no newer match is rated before it")
+	} else if err := clearCheckpoint(mode); err != nil {
+		// we made it to the end, the next run starts over
+		fmt.Fprintf(out, "Failed to clear checkpoint: %v\n", err)
+	}
+
+	if report != nil {
+		report.Print(out, *reportFormat)
+	}
+	return nil
+}
+
+// Stays up, scraping on schedule & whenever the trigger endpoint is hit.
+// Only one scrape runs at a time; a trigger during a run is turned away.
+func runDaemon(settings *config.Config, sched schedule.Schedule) {
+	triggers := make(chan string)
+	if *listenAddr != "" {
+		go listenForTriggers(*listenAddr, triggers)
+	}
+
+	for {
+		next := sched.Next(time.Now())
+		fmt.Fprintf(out, "Next scheduled scrape at %s\n", next.Format(LOG_TIME_FORMAT))
+
+		var trigger string
+		select {
+		case <-time.After(next.Sub(time.Now())):
+			trigger = "schedule"
+		case trigger = <-triggers:
+		}
+
+		fmt.Fprintf(out, "Starting %s scrape\n", trigger)
+		if err := lockedRun(trigger, func() error { return scrape(settings) }); err != nil {
+			fmt.Fprintf(out, "Scrape failed: %v\n", err)
 		}
+		fmt.Fprintln(out)
 	}
+}
 
-	for _, tournyId := range tourneys {
-		pageNum := 1
-		for {
-			fmt.Printf("Processing Tournament #%d, Page #%d\n", tournyId, pageNum)
-			hasNextPage, err := processTournament(client, tournyId, pageNum)
-			if err != nil {
-				fmt.Printf("Failed to parse tournament page: %v\n", err)
-				break
-			}
-			if !hasNextPage {
-				break
-			}
-			fmt.Println()
-			pageNum++
+// listen on an http endpoint for requests to scrape right now
+func listenForTriggers(addr string, triggers chan<- string) {
+	http.HandleFunc(TRIGGER_ENDPOINT, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.WriteHeader(405)
+			return
+		}
+		select {
+		case triggers <- "http":
+			w.Header().Set("X-Success", "true")
+			w.WriteHeader(202)
+		default:
+			// the daemon's busy scraping, it'll pick up new matches next time anyway
+			w.Header().Set("X-Success", "false")
+			w.Header().Set("X-Error", ErrScrapeRunning.Error())
+			w.WriteHeader(409)
 		}
-		fmt.Println()
+	})
+	fmt.Fprintf(out, "Listening for scrape triggers at http://%s%s\n", addr, TRIGGER_ENDPOINT)
+	if err := http.ListenAndServe(addr, nil); err != nil {
+		fmt.Fprintf(out, "%v\n", err)
 	}
-	relayToBot(fmt.Sprintf("Scheduled scrape complete, bot information is up to date."))
+}
+
+func hostname() string {
+	host, _ := os.Hostname()
+	return host
 }
 
 // returns an absolute salty url based on a fragment
@@ -127,48 +526,237 @@ func saltyUrl(format string, args ...interface{}) string {
 	return fmt.Sprintf("http://www.saltybet.com/%s", strings.TrimPrefix(rel, "/"))
 }
 
-// checks to ensure we have data to scrape
-func illuminatiCheck(rows []xml.Node) (err error) {
-	if len(rows) == 0 {
-		err = errors.New("unable to find tournaments/fight records, has your illuminati subscription run out?")
+// checks to ensure we have data to scrape. An empty page is checked against the session, so a
+// lapsed subscription is reported as one rather than as a page with nothing on it.
+func illuminatiCheck(rows []xml.Node) error {
+	if len(rows) > 0 {
+		return nil
 	}
-	return
+	if sessions != nil {
+		if state, _ := sessions.Check(); state == session.Lapsed {
+			return session.ErrLapsed
+		}
+	}
+	return &fetch.EmptyPageError{}
 }
 
 // grab all characters in the compendium & add/update them.
-func getRoster(c *http.Client) error {
-	fmt.Printf("- Scraping Compendium\n")
+func getRoster(c *http.Client) (entries []RosterEntry, err error) {
+	fmt.Fprintf(out, "- Scraping Compendium\n")
 	doc, err := getGokogiriDoc(c, saltyUrl("compendium?search="))
 	if err != nil {
-		return err
+		return nil, err
 	}
-	rows, _ := doc.Search("//ul[@id='tierlist']/li/a")
+	rows, _ := doc.Search(profile.RosterLinks)
 	for _, r := range rows {
-		nums := numRx.FindAllString(r.Attribute("href").String(), 2)
+		// the selectors come from the config, so anything could turn up here
+		attr, label := r.Attribute("href"), r.FirstChild()
+		if attr == nil || label == nil {
+			fmt.Fprintf(out, "--Skipping roster entry without a link or name: %s\n", r.String())
+			continue
+		}
+		href := attr.String()
+		nums := numRx.FindAllString(href, 2)
+		if len(nums) < 2 {
+			fmt.Fprintf(out, "--Skipping roster entry without a tier & character id in its link: %s\n", href)
+			continue
+		}
 		tier, _ := strconv.Atoi(nums[0])
 		cid, _ := strconv.Atoi(nums[1])
-		name := nameSub(html.UnescapeString(r.FirstChild().String()))
+		name := names.Resolve(html.UnescapeString(label.String()))
 
-		fighter, _ := repo.GetFighter(name)
+		fighter := lookupFighter(name)
+		if report != nil {
+			report.AddRosterEntry(fighter, name, tier)
+		}
+		if fighter.Id == 0 {
+			atomic.AddInt64(&summary.FightersAdded, 1)
+		} else if fighter.Tier != tier || fighter.CharacterId != cid {
+			atomic.AddInt64(&summary.FightersUpdated, 1)
+		}
 
 		fighter.CharacterId = cid
 		fighter.Name = name
 		fighter.Tier = tier
+		entries = append(entries, RosterEntry{Fighter: fighter, Href: href})
+		if report != nil {
+			continue
+		}
 		if err := repo.UpdateFighter(fighter); err != nil {
-			fmt.Printf("Failed to update fighter #%d - '%s': %v\n", cid, name, err)
+			fmt.Fprintf(out, "Failed to update fighter #%d - '%s': %v\n", cid, name, err)
 		}
 	}
-	return nil
+	return entries, nil
+}
+
+// Marks everyone on the tier list as seen, & retires fighters who've been missing from it for
+// -retire-after scrapes in a row. An empty tier list is more likely a broken page than everyone
+// retiring at once, so nobody's counted as missing from one.
+func retireMissing(entries []RosterEntry) {
+	if len(entries) == 0 {
+		fmt.Fprintln(out, "Tier list was empty, not checking for retired fighters")
+		return
+	}
+
+	scraped := time.Now()
+	var retired []string
+	err := withTrans(func(tx *sql.Tx) error {
+		for _, entry := range entries {
+			f := entry.Fighter
+			if f.Id == 0 {
+				continue
+			}
+			wasRetired, e := roster.Seen(tx, f.Id, scraped)
+			if e != nil {
+				return e
+			}
+			if wasRetired && report != nil {
+				report.Unretired = append(report.Unretired, f.Name)
+			} else if wasRetired {
+				fmt.Fprintf(out, "--'%s' is back from retirement\n", f.Name)
+			}
+		}
+		var e error
+		retired, e = roster.Sweep(tx, scraped, *retireAfter)
+		return e
+	})
+	if err != nil {
+		fmt.Fprintf(out, "Failed to check for retired fighters: %v\n", err)
+		return
+	}
+	if report != nil {
+		report.Retired = retired
+		return
+	}
+
+	for _, name := range retired {
+		fmt.Fprintf(out, "--'%s' has retired\n", name)
+	}
+	atomic.AddInt64(&summary.FightersRetired, int64(len(retired)))
+	summary.Retired = retired
+}
+
+// Scrapes the compendium page of each fighter whose details haven't been scraped in -details-age
+// for the attributes listed there, recording any that changed. Fighters that aren't stored yet
+// (new ones, in a dry run) are left for next time. A dry run fetches nothing, it just reports
+// whose pages are due.
+func getFighterDetails(c *http.Client, entries []RosterEntry) {
+	var checked map[int]time.Time
+	err := withTrans(func(tx *sql.Tx) (e error) {
+		checked, e = compendium.Checked(tx)
+		return
+	})
+	if err != nil {
+		fmt.Fprintf(out, "Failed to load when fighter details were last scraped: %v\n", err)
+		return
+	}
+
+	stale := time.Now().Add(-*detailsAge)
+	for _, entry := range entries {
+		f := entry.Fighter
+		if at, ok := checked[f.Id]; f.Id == 0 || (ok && at.After(stale)) {
+			continue
+		}
+		if report != nil {
+			report.DetailsDue = append(report.DetailsDue, f.Name)
+			continue
+		}
+		doc, err := getGokogiriDoc(c, saltyUrl("%s", entry.Href))
+		if err != nil {
+			atomic.AddInt64(&summary.PagesFailed, 1)
+			fmt.Fprintf(out, "Failed to fetch compendium page for '%s': %v\n", f.Name, err)
+			continue
+		}
+		atomic.AddInt64(&summary.PagesFetched, 1)
+
+		attrs := profile.ParseAttributes(doc)
+		if len(attrs) == 0 {
+			fmt.Fprintf(out, "No details found for '%s'\n", f.Name)
+			if err = withTrans(func(tx *sql.Tx) error { return compendium.MarkChecked(tx, f.Id) }); err != nil {
+				fmt.Fprintf(out, "Failed to note details were scraped for '%s': %v\n", f.Name, err)
+			}
+			continue
+		}
+		var changes []compendium.Change
+		err = withTrans(func(tx *sql.Tx) (e error) {
+			changes, e = compendium.Save(tx, f.Id, attrs)
+			return
+		})
+		if err != nil {
+			fmt.Fprintf(out, "Failed to save details for '%s': %v\n", f.Name, err)
+			continue
+		}
+		for _, ch := range changes {
+			fmt.Fprintf(out, "--%s: %s '%s' -> '%s'\n", f.Name, ch.Attribute, ch.Old, ch.New)
+		}
+		atomic.AddInt64(&summary.DetailsChanged, int64(len(changes)))
+	}
 }
 
-// For an entire re-scrape, this will be all the valid tournament ids to scrape.
-// Rough estimate on first matchmaking fight: Snake Eyes vs Namor; tournament #101, match #51966
-func getAllTournamentIds() ([]int, error) {
-	return []int{
-		101, 102, 103, 104, 105,
-		106, 107, 108, 109, 110,
-		111, 112, 113, 114, 115,
-		116, 117}, nil
+// For an entire re-scrape, this will be all the valid tournament ids between min & max (inclusive,
+// max of 0 meaning no limit), oldest first. Walks the tournament stats pages for ids we haven't seen
+// before & caches them, stopping at the first page of already known tournaments once a walk has made
+// it to the last page (never with -rediscover), so a walk that died partway is picked back up.
+func getAllTournamentIds(c *http.Client, min, max int) ([]int, error) {
+	walked, err := loadCheckpoint(DISCOVERY_MODE)
+	if err != nil {
+		return nil, err
+	}
+	if walked == nil {
+		fmt.Fprintln(out, "- No complete walk of the tournament stats pages yet, walking all of them")
+	}
+
+	var discovered []int
+	for pageNum := 1; ; pageNum++ {
+		fmt.Fprintf(out, "- Tournament Stats Page #%d\n", pageNum)
+		doc, err := getGokogiriDoc(c, saltyUrl("stats?tournamentstats=1&page=%d", pageNum))
+		if err != nil {
+			return nil, err
+		}
+
+		rows, _ := doc.Search(profile.TableRows)
+		if err = illuminatiCheck(rows); err != nil {
+			return nil, err
+		}
+
+		ids := profile.ParseTournamentIds(rows)
+		discovered = append(discovered, ids...)
+		added, err := cacheTournamentIds(ids)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(out, "--New Tournaments: %d\n", added)
+
+		nextpage, _ := doc.Search(profile.NextPage)
+		if len(nextpage) == 0 {
+			if walked == nil {
+				saveCheckpoint(&checkpoint.Checkpoint{Mode: DISCOVERY_MODE, Page: pageNum})
+			}
+			break
+		}
+		if added == 0 && walked != nil && !*rediscover {
+			break
+		}
+	}
+
+	ids, err := loadTournamentIds(min, max)
+	if err != nil || !*dryRun {
+		return ids, err
+	}
+
+	// a dry run never commits the cache, so fold in what we found along the way
+	known := make(map[int]bool)
+	for _, id := range ids {
+		known[id] = true
+	}
+	for _, id := range discovered {
+		if !known[id] && id >= min && (max == 0 || id <= max) {
+			known[id] = true
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+	return ids, nil
 }
 
 // Returns an array of the ids of the last n tournaments.
@@ -178,167 +766,914 @@ func getLatestTournamentIds(c *http.Client, count int) ([]int, error) {
 		return nil, err
 	}
 
-	rows, _ := doc.Search(fmt.Sprintf("//table/tbody/tr[position() <= %d]", count))
+	rows, _ := doc.Search(profile.TableRows)
 	if err = illuminatiCheck(rows); err != nil {
 		return nil, err
 	}
+	if len(rows) > count {
+		rows = rows[:count]
+	}
+
+	return profile.ParseTournamentIds(rows), nil
+}
+
+// Fetches tournaments concurrently across a pool of workers, but writes them one at a time in
+// the order given (matches sorted by id within each) so rating updates stay deterministic.
+// The first tournament starts at startPage, the rest from page 1. Fetching only runs a couple of
+// tournaments per worker ahead of the writer, so a full rebuild isn't held in memory.
+// Everything stops at the first page that fails; returns whether every tournament was written.
+func scrapeTournaments(c *http.Client, mode string, tourneys []int, startPage, workerCount int) bool {
+	if workerCount < 1 {
+		workerCount = 1
+	}
+
+	// each tournament gets its own slot, so the writer can wait on them in order
+	done := make([]chan *ScrapedTournament, len(tourneys))
+	for i := range done {
+		done[i] = make(chan *ScrapedTournament, 1)
+	}
+
+	jobs := make(chan int)
+	ahead := make(chan bool, 2*workerCount)
+	stop := make(chan bool)
+	defer close(stop)
+	for w := 0; w < workerCount; w++ {
+		go func() {
+			for i := range jobs {
+				page := 1
+				if i == 0 {
+					page = startPage
+				}
+				done[i] <- fetchTournament(c, tourneys[i], page, stop)
+			}
+		}()
+	}
+	go func() {
+		defer close(jobs)
+		for i := range tourneys {
+			select {
+			case ahead <- true:
+			case <-stop:
+				return
+			}
+			select {
+			case jobs <- i:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	for i := range tourneys {
+		ok := importTournament(mode, <-done[i])
+		<-ahead
+		fmt.Fprintln(out)
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Walks a tournament page by page starting at pageNum, collecting its matches. Stops at the
+// first page that fails, the error & page are kept on the result, or once stop is closed.
+func fetchTournament(c *http.Client, tournyId, pageNum int, stop <-chan bool) *ScrapedTournament {
+	t := &ScrapedTournament{Id: tournyId}
+	for {
+		select {
+		case <-stop:
+			t.Err = errStopped
+			return t
+		default:
+		}
+		fmt.Fprintf(out, "Fetching Tournament #%d, Page #%d\n", tournyId, pageNum)
+		matches, rejects, hasNextPage, err := fetchTournamentPage(c, tournyId, pageNum)
+		t.LastPage = pageNum
+		if err != nil {
+			t.Err = err
+			break
+		}
+		t.Matches = append(t.Matches, matches...)
+		t.Rejects = append(t.Rejects, rejects...)
+		if !hasNextPage {
+			break
+		}
+		pageNum++
+	}
+	return t
+}
+
+// Writes a fetched tournament oldest match first, a page per transaction. Each page is checkpointed
+// as it's committed, & the tournament once it's done, so a crash partway through a long tournament
+// resumes at the next page. Ratings have to be built up in match order, so nothing is written past
+// a page that fails to fetch or import: it's recorded, the checkpoint is left at the page before it
+// & false is returned, to stop the scrape there. The next run starts again from that page.
+func importTournament(mode string, t *ScrapedTournament) bool {
+	fmt.Fprintf(out, "Processing Tournament #%d\n", t.Id)
+	sort.Sort(parser.ByMatchId(t.Matches))
+	quarantineRows(t.Rejects)
+	lastMatchId := 0
+	for _, page := range parser.SplitPages(t.Matches) {
+		last, err := importMatches(page)
+		if err != nil {
+			fmt.Fprintf(out, "Failed to import tournament #%d, page #%d: %v\n", t.Id, page[0].Page, err)
+			clearFailedPages(t.Id, page[0].Page)
+			return pageFailed(mode, t.Id, page[0].Page, lastMatchId, err)
+		}
+		lastMatchId = last
+		saveCheckpoint(&checkpoint.Checkpoint{Mode: mode, TournamentId: t.Id, Page: page[0].Page, HasNext: page[0].Page < t.LastPage, LastMatchId: last})
+	}
 
-	result := make([]int, count)
-	for i, r := range rows {
-		cols, _ := r.Search("td")
-		id, _ := strconv.Atoi(numRx.FindString(cols[0].FirstChild().Attribute("href").String()))
-		result[i] = id
+	if t.Err != nil {
+		fmt.Fprintf(out, "Failed to parse tournament #%d, page #%d: %v\n", t.Id, t.LastPage, t.Err)
+		clearFailedPages(t.Id, t.LastPage)
+		return pageFailed(mode, t.Id, t.LastPage, lastMatchId, t.Err)
 	}
-	return result, nil
+
+	clearFailedPages(t.Id, t.LastPage+1)
+	saveCheckpoint(&checkpoint.Checkpoint{Mode: mode, TournamentId: t.Id, Page: t.LastPage, LastMatchId: lastMatchId})
+	return true
 }
 
-// Runs through a tournament page, adding matches & updating fighter information
-func processTournament(c *http.Client, id, pageNum int) (bool, error) {
+// Records a page that failed to fetch or import & leaves the checkpoint just before it, so the
+// next run starts again from that page. Once it's failed -page-attempts times the rest of the
+// tournament is given up on instead, & the scrape carries on with the next one.
+func pageFailed(mode string, tournyId, page, lastMatchId int, cause error) bool {
+	atomic.AddInt64(&summary.PagesFailed, 1)
+	attempts, rErr := recordFailedPage(tournyId, page, cause)
+	if rErr != nil {
+		fmt.Fprintf(out, "Failed to record failed page: %v\n", rErr)
+	}
+	if attempts >= *pageAttempts {
+		fmt.Fprintf(out, "Giving up on Tournament #%d from Page #%d after %d attempts, it's left in scrape_failed_pages\n",
+			tournyId, page, attempts)
+		saveCheckpoint(&checkpoint.Checkpoint{Mode: mode, TournamentId: tournyId, Page: page, LastMatchId: lastMatchId})
+		return true
+	}
+	saveCheckpoint(&checkpoint.Checkpoint{Mode: mode, TournamentId: tournyId, Page: page - 1, HasNext: true, LastMatchId: lastMatchId})
+	return false
+}
+
+// Fetches & parses a single tournament page without touching the database.
+// Returns the parsed matches, the rows that wouldn't parse & whether there's another page.
+func fetchTournamentPage(c *http.Client, id, pageNum int) ([]*parser.Match, []*quarantine.Row, bool, error) {
 	doc, err := getGokogiriDoc(c, saltyUrl("stats?tournament_id=%d&page=%d", id, pageNum))
 	if err != nil {
-		return false, err
+		return nil, nil, false, err
 	}
 
-	rows, _ := doc.Search("//table/tbody/tr")
+	rows, _ := doc.Search(profile.TableRows)
 	if err = illuminatiCheck(rows); err != nil {
-		return false, err
+		return nil, nil, false, err
 	}
-	nextpage, _ := doc.Search("//div[@id='pagination']//a[text()='Next']")
+	nextpage, _ := doc.Search(profile.NextPage)
 
-	scrapeRows(rows)
-	return len(nextpage) > 0, nil
+	atomic.AddInt64(&summary.PagesFetched, 1)
+	matches, rejects := parseRows(rows, id, pageNum)
+	return matches, rejects, len(nextpage) > 0, nil
 }
 
-// Returns a gokogiri html.Document from a url
-func getGokogiriDoc(c *http.Client, url string) (*ghtml.HtmlDocument, error) {
-	resp, err := c.Get(url)
-	if err != nil {
-		return nil, err
+// Returns a gokogiri html.Document from a url, retrying with backoff on timeouts, rate limits
+// & server errors while the request's retries & the run's retry budget last.
+func getGokogiriDoc(c *http.Client, pageUrl string) (*ghtml.HtmlDocument, error) {
+	if pageArchive != nil && pageArchive.Replaying() {
+		page, err := pageArchive.Load(pageUrl)
+		if err != nil {
+			return nil, err
+		}
+		return gokogiri.ParseHtml(page)
 	}
-	defer resp.Body.Close()
 
-	page, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
+	var page []byte
+	var err error
+	reauthed := false
+	for attempt := 0; ; attempt++ {
+		if page, err = fetch.Page(c, throttle, pageUrl); err == nil {
+			break
+		}
+		if _, expired := err.(*fetch.AuthError); expired && sessions != nil && !reauthed {
+			// the session ran out mid-scrape; log back in (the client picks up the new cookies) & go again
+			reauthed = true
+			if rErr := sessions.Reauthenticate(); rErr != nil {
+				return nil, fmt.Errorf("%v, & logging back in failed: %v", err, rErr)
+			}
+			fmt.Fprintf(out, "--Session expired, logged back in\n")
+			continue
+		}
+		if !fetch.Retryable(err) || attempt >= *maxRetries || atomic.AddInt64(&retriesLeft, -1) < 0 {
+			return nil, err
+		}
+		wait := fetch.Backoff(attempt, err)
+		fmt.Fprintf(out, "--%v, retrying in %v\n", err, wait)
+		time.Sleep(wait)
 	}
 
+	if pageArchive != nil {
+		if err := pageArchive.Save(pageUrl, page); err != nil {
+			fmt.Fprintf(out, "--Failed to archive %s: %v\n", pageUrl, err)
+		}
+	}
 	return gokogiri.ParseHtml(page)
 }
 
-// Scrape a match row, parsing information & storing it
-func scrapeRows(rows []xml.Node) {
-	skipped, updated := 0, 0
+// Parse the match rows of a tournament page, setting aside any that don't parse
+func parseRows(rows []xml.Node, tournyId, pageNum int) (matches []*parser.Match, rejects []*quarantine.Row) {
+	matches = make([]*parser.Match, 0, len(rows))
 	for _, r := range rows {
-		pm, err := GetParsedMatch(r)
-		if err != nil {
-			fmt.Printf("Error parsing match id #%d: %v\n", pm.MatchId, err)
+		pm, err := profile.ParseMatch(r, names)
+		if err != nil && pm.MatchId != 0 && pm.MatchId < parser.FIRST_MATCHMAKING_MATCH {
+			atomic.AddInt64(&summary.MatchesSkipped, 1)
+			continue
+		} else if err != nil {
+			atomic.AddInt64(&summary.MatchesErrored, 1)
+			fmt.Fprintf(out, "Error parsing match id #%d: %v\n", pm.MatchId, err)
+			rejects = append(rejects, &quarantine.Row{TournamentId: tournyId, Page: pageNum, Html: r.String(), Error: err.Error()})
+			continue
+		}
+		if pm.Red == pm.Blue {
+			// two fighters since merged; Merge dropped the match, so a rebuild shouldn't bring it back
+			atomic.AddInt64(&summary.MatchesSkipped, 1)
 			continue
 		}
+		pm.TournamentId, pm.Page = tournyId, pageNum
+		matches = append(matches, pm)
+	}
+	return
+}
 
-		if !repo.MatchExists(pm.MatchId) {
-			red_fighter, _ := repo.GetFighter(pm.Red)
-			blue_fighter, _ := repo.GetFighter(pm.Blue)
-			red_fighter.TotalBets += pm.RedBets
-			blue_fighter.TotalBets += pm.BlueBets
-			spicerack.UpdateFighterElo(red_fighter, blue_fighter, pm.FightWinner)
-
-			if tx, e := repo.StartTransaction(); e == nil {
-				if rErr := repo.UpdateFighterInTrans(red_fighter, tx); rErr != nil {
-					fmt.Printf("--Skipping match, failed to update fighter: %v\n", rErr)
-					tx.Rollback()
-				} else if bErr := repo.UpdateFighterInTrans(blue_fighter, tx); bErr != nil {
-					fmt.Printf("--Skipping match, failed to update fighter: %v\n", bErr)
-					tx.Rollback()
-				} else {
-					m := &spicerack.Match{
-						MatchId: pm.MatchId,
-						RedId:   red_fighter.Id, BlueId: blue_fighter.Id,
-						RedBets: pm.RedBets, BlueBets: pm.BlueBets,
-						BetCount: pm.Bettors, Winner: int(pm.FightWinner),
-						Created: time.Now(), Updated: time.Now()}
-
-					if mErr := repo.InsertMatch(m); mErr == nil {
-						tx.Commit()
-						updated++
-					} else {
-						tx.Rollback()
-						fmt.Printf("--Failed to insert match #%d: %v\n", pm.MatchId, mErr)
-					}
+// Store parsed matches in the order given, updating fighter information. The whole batch is one
+// transaction; if any match fails nothing is kept, so the page can simply be imported again.
+// Matches we already have are skipped, unless they were unresolved & now have a winner.
+// Returns the last match id seen.
+func importMatches(matches []*parser.Match) (lastMatchId int, err error) {
+	var skipped, updated, resolved int
+	err = withTrans(func(tx *sql.Tx) error {
+		fighters := make(batchFighters)
+		for _, pm := range matches {
+			lastMatchId = pm.MatchId
+			exists, e := matchStored(tx, pm.MatchId)
+			if e != nil {
+				return fmt.Errorf("failed to look up match #%d: %v", pm.MatchId, e)
+			}
+			unresolved := false
+			if exists && pm.Decided() {
+				if unresolved, e = outcomes.Unresolved(tx, pm.MatchId); e != nil {
+					return fmt.Errorf("failed to check outcome of match #%d: %v", pm.MatchId, e)
 				}
 			}
-		} else {
-			skipped++
+
+			switch {
+			case !exists:
+				if e := storeMatch(tx, fighters, pm, false); e != nil {
+					return fmt.Errorf("match #%d: %v", pm.MatchId, e)
+				}
+				updated++
+			case unresolved:
+				if e := storeMatch(tx, fighters, pm, true); e != nil {
+					return fmt.Errorf("failed to resolve match #%d: %v", pm.MatchId, e)
+				}
+				resolved++
+			case report != nil:
+				skipped++
+			default:
+				// rebuilds fill in whatever older matches are missing
+				if e := saveDetails(tx, pm, false); e != nil {
+					return fmt.Errorf("failed to backfill match #%d: %v", pm.MatchId, e)
+				}
+				skipped++
+			}
 		}
+		return nil
+	})
+	if err != nil {
+		fmt.Fprintf(out, "--Rolled back %d matches: %v\n", len(matches), err)
+		atomic.AddInt64(&summary.MatchesErrored, int64(len(matches)))
+		return
+	}
+
+	fmt.Fprintf(out, "--Skipped: %d | New Matches: %d | Resolved: %d\n", skipped, updated, resolved)
+	atomic.AddInt64(&summary.MatchesNew, int64(updated))
+	atomic.AddInt64(&summary.MatchesResolved, int64(resolved))
+	atomic.AddInt64(&summary.MatchesSkipped, int64(skipped))
+	return
+}
+
+// Fighters touched by a batch of matches. Later matches in the batch build on the rating & bet
+// changes of earlier ones, which aren't visible outside the transaction until it commits.
+type batchFighters map[string]*spicerack.Fighter
+
+func (b batchFighters) get(name string) *spicerack.Fighter {
+	if f, ok := b[name]; ok {
+		return f
 	}
-	fmt.Printf("--Skipped: %d | New Matches: %d\n", skipped, updated)
+	f := lookupFighter(name)
+	b[name] = f
+	return f
 }
 
-// Parse a match row into a managed object
-func GetParsedMatch(n xml.Node) (pm *ParsedMatch, err error) {
-	pm = &ParsedMatch{}
-	match_url, _ := n.Search("td/a/@href")
-	red, _ := n.Search("td/a/span[@class='redtext']/text()")
-	redvalue, _ := n.Search("td/a/span[@class='redtext']/following-sibling::text()")
-	blue, _ := n.Search("td/a/span[@class='bluetext']/text()")
-	bluevalue, _ := n.Search("td/a/span[@class='bluetext']/following-sibling::text()")
-	winner, _ := n.Search("td[position() = 2]/span/text()")
-	bettors, _ := n.Search("td[last()]/text()")
+// Writes a match & its fighters as part of tx. Only decided matches move ratings & bet totals, or
+// go in the matches table at all; draws, cancellations & unresolved matches only have their outcome
+// recorded, so nothing reading matches can take a winner of 0 for a result. When resolving, a match
+// row from before that was the case gets its winner instead of a new row being inserted.
+func storeMatch(tx *sql.Tx, fighters batchFighters, pm *parser.Match, resolving bool) error {
+	red_fighter := fighters.get(pm.Red)
+	blue_fighter := fighters.get(pm.Blue)
+	redElo, blueElo := red_fighter.Elo, blue_fighter.Elo
+	if pm.Decided() {
+		red_fighter.TotalBets += pm.RedBets
+		blue_fighter.TotalBets += pm.BlueBets
+		spicerack.UpdateFighterElo(red_fighter, blue_fighter, pm.FightWinner)
+	}
 
-	if len(match_url) > 0 {
-		pm.MatchId, _ = strconv.Atoi(numRx.FindString(match_url[0].String()))
+	if report != nil {
+		report.AddMatch(pm)
+		return nil
 	}
-	if len(redvalue) > 0 {
-		pm.RedBets, _ = strconv.Atoi(numRx.FindString(redvalue[0].String()))
+
+	if err := repo.UpdateFighterInTrans(red_fighter, tx); err != nil {
+		return fmt.Errorf("failed to update fighter: %v", err)
 	}
-	if len(bluevalue) > 0 {
-		pm.BlueBets, _ = strconv.Atoi(numRx.FindString(bluevalue[0].String()))
+	if err := repo.UpdateFighterInTrans(blue_fighter, tx); err != nil {
+		return fmt.Errorf("failed to update fighter: %v", err)
 	}
 
-	pm.Red = nameSub(html.UnescapeString(red[0].String()))
-	pm.Blue = nameSub(html.UnescapeString(blue[0].String()))
-	pm.Bettors, _ = strconv.Atoi(bettors[0].String())
-	if len(winner) > 0 {
-		pm.Winner = nameSub(html.UnescapeString(winner[0].String()))
-		if pm.Winner == pm.Red {
-			pm.FightWinner = spicerack.WINNER_RED
-		} else if pm.Winner == pm.Blue {
-			pm.FightWinner = spicerack.WINNER_BLUE
+	var err error
+	var updated int64
+	if resolving {
+		var res sql.Result
+		if res, err = tx.Exec(`UPDATE matches SET winner = $2, updated = $3 WHERE match_id = $1`,
+			pm.MatchId, int(pm.FightWinner), time.Now()); err == nil {
+			updated, _ = res.RowsAffected()
 		}
 	}
+	if err == nil && updated == 0 && pm.Decided() {
+		m := &spicerack.Match{
+			MatchId: pm.MatchId,
+			RedId:   red_fighter.Id, BlueId: blue_fighter.Id,
+			RedBets: pm.RedBets, BlueBets: pm.BlueBets,
+			BetCount: pm.Bettors, Winner: int(pm.FightWinner),
+			Created: time.Now(), Updated: time.Now()}
+		err = repo.InsertMatchInTrans(m, tx)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to store match: %v", err)
+	}
 
-	if pm.MatchId == 0 {
-		err = errors.New("Unable to parse match id.")
-	} else if len(pm.Red) == 0 || len(pm.Blue) == 0 {
-		err = errors.New("Red or Blue fighter is an empty string.")
-	} else if int(pm.FightWinner) == 0 {
-		err = errors.New("No winner found.")
-	} else if pm.MatchId < 51966 {
-		err = errors.New("Pre-matchmaking fight. Ignored.")
+	if pm.Decided() {
+		if err = saveSnapshot(tx, pm.MatchId, red_fighter, redElo); err != nil {
+			return fmt.Errorf("failed to store rating snapshot: %v", err)
+		}
+		if err = saveSnapshot(tx, pm.MatchId, blue_fighter, blueElo); err != nil {
+			return fmt.Errorf("failed to store rating snapshot: %v", err)
+		}
+	}
+	if resolving || !pm.Decided() {
+		if err = outcomes.Save(tx, pm.MatchId, pm.Outcome, pm.Winner); err != nil {
+			return fmt.Errorf("failed to store outcome: %v", err)
+		}
 	}
+	if err = saveDetails(tx, pm, !resolving); err != nil {
+		return fmt.Errorf("failed to store match details: %v", err)
+	}
+	return nil
+}
 
-	return pm, err
+// Records a fighter's rating going into & coming out of a match, along with their bet total after it
+func saveSnapshot(tx *sql.Tx, matchId int, f *spicerack.Fighter, eloBefore int) error {
+	_, err := tx.Exec(`INSERT INTO rating_snapshots (match_id, fighter_id, elo_before, elo_after, total_bets, created)
+		VALUES ($1, $2, $3, $4, $5, $6)`, matchId, f.Id, eloBefore, f.Elo, f.TotalBets, time.Now())
+	return err
 }
 
-func nameSub(name string) string {
-	// way to go salty, unescaped brackets in html HOW CAN YOU GO WRONG
-	if name == " ( 0)/2" {
-		return "<> ( 0)<>/2"
+// handles the -alias, -unalias, -aliases & -merge flags
+func manageAliases() error {
+	switch {
+	case *addAlias != "":
+		parts := strings.SplitN(*addAlias, "=", 2)
+		if len(parts) != 2 {
+			return errors.New("-alias expects \"alias=canonical name\"")
+		}
+		if err := aliases.Add(repo, parts[0], parts[1]); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "'%s' is now an alias of '%s'\n", strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+	case *removeAlias != "":
+		if err := aliases.Remove(repo, *removeAlias); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "Removed alias '%s'\n", *removeAlias)
+	case *mergeFighter != "":
+		parts := strings.SplitN(*mergeFighter, "=", 2)
+		if len(parts) != 2 {
+			return errors.New("-merge expects \"from=into\"")
+		}
+		from, into := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		dropped, err := aliases.Merge(repo, from, into)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "Merged '%s' into '%s', dropping %d matches between them; ratings won't reflect it until the next -reset-elo -salt-the-earth\n",
+			from, into, dropped)
+	case *listAliases:
+		list, err := aliases.List(repo)
+		if err != nil {
+			return err
+		}
+		for _, a := range list {
+			fmt.Fprintf(out, "%s <- %s\n", a.Canonical, a.Alias)
+		}
 	}
-	return name
+	return nil
 }
 
 // Sends messages to the shaker bot, if listening on this machine at port 4380
 func relayToBot(msg string) {
-	form := url.Values{
-		"Message": {msg},
+	if err := relay.ToBot(msg); err != nil {
+		fmt.Fprintf(out, "Failed to send message to IRC bot: %v\n", err)
 	}
+}
 
-	host, _ := os.Hostname()
-	port := 4380
-	url := fmt.Sprintf("http://%s:%d/shaker/bot/talk", host, port)
-	if resp, err := http.PostForm(url, form); err != nil {
-		fmt.Printf("Failed to send message to IRC bot: %v\n", err)
+// Runs fn in its own transaction, committing if it succeeds (unless this is a dry run)
+func withTrans(fn func(tx *sql.Tx) error) error {
+	return runTrans(fn, !*dryRun)
+}
+
+// Runs fn in its own transaction, committing if it succeeds & commit is set, rolling back otherwise
+func runTrans(fn func(tx *sql.Tx) error, commit bool) error {
+	tx, err := repo.StartTransaction()
+	if err != nil {
+		return err
+	}
+	if err = fn(tx); err != nil || !commit {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Everything a run makes sure of in the database before it starts, in order
+var setupSteps = []struct {
+	Does string
+	run  func(backend string) error
+}{
+	{"create the scrape tables", func(string) error { return runTrans(createScrapeTables, true) }},
+	{"add a heartbeat to the scrape lock", func(string) error { return lock.AddHeartbeat(repo) }},
+	{"let match details go without tiers", allowUnknownTiers},
+	{"clear undecided matches", func(string) error { return withTrans(outcomes.DropUndecided) }},
+	{"create the alias table", func(string) error { return aliases.CreateTable(repo) }},
+	{"create the fighter detail tables", func(string) error { return compendium.CreateTables(repo) }},
+	{"create the fighter status table", func(string) error { return roster.CreateTable(repo) }},
+}
+
+// Tables the setup steps make that aren't there yet
+func missingTables() (missing []string) {
+	for _, table := range []string{"scrape_checkpoints", "scrape_failed_pages", "scrape_tournaments", "scrape_lock", "scrape_runs",
+		"match_outcomes", "match_details", "match_quarantine", "rating_snapshots", "fighter_aliases",
+		"fighter_attributes", "fighter_attribute_history", "fighter_attribute_checks", "fighter_status"} {
+		err := runTrans(func(tx *sql.Tx) error {
+			_, e := tx.Exec(`SELECT 1 FROM ` + table + ` WHERE 1 = 0`)
+			return e
+		}, false)
+		if err != nil {
+			missing = append(missing, table)
+		}
+	}
+	return
+}
+
+func createScrapeTables(tx *sql.Tx) error {
+	for _, q := range []string{checkpoint.CREATE_CHECKPOINTS_SQL, CREATE_FAILED_PAGES_SQL, CREATE_TOURNAMENTS_SQL, lock.CREATE_LOCK_SQL, CREATE_RUNS_SQL, outcomes.CREATE_OUTCOMES_SQL, CREATE_MATCH_DETAILS_SQL, quarantine.CREATE_QUARANTINE_SQL, CREATE_SNAPSHOTS_SQL} {
+		if _, err := tx.Exec(q); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func loadCheckpoint(mode string) (cp *checkpoint.Checkpoint, err error) {
+	err = withTrans(func(tx *sql.Tx) (e error) {
+		cp, e = checkpoint.Load(tx, mode)
+		return
+	})
+	return
+}
+
+func saveCheckpoint(cp *checkpoint.Checkpoint) {
+	if err := withTrans(func(tx *sql.Tx) error { return checkpoint.Save(tx, cp) }); err != nil {
+		fmt.Fprintf(out, "Failed to save checkpoint: %v\n", err)
+	}
+}
+
+func clearCheckpoint(mode string) error {
+	return withTrans(func(tx *sql.Tx) error { return checkpoint.Clear(tx, mode) })
+}
+
+// Records (or bumps the attempt count of) a page that failed to scrape, returning how many times it has
+func recordFailedPage(tournyId, page int, cause error) (attempts int, err error) {
+	err = withTrans(func(tx *sql.Tx) error {
+		res, e := tx.Exec(`UPDATE scrape_failed_pages SET attempts = attempts + 1, error = $3, updated = $4
+			WHERE tournament_id = $1 AND page = $2`, tournyId, page, cause.Error(), time.Now())
+		if e != nil {
+			return e
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			if _, e = tx.Exec(`INSERT INTO scrape_failed_pages (tournament_id, page, error, updated)
+				VALUES ($1, $2, $3, $4)`, tournyId, page, cause.Error(), time.Now()); e != nil {
+				return e
+			}
+		}
+		return tx.QueryRow(`SELECT attempts FROM scrape_failed_pages WHERE tournament_id = $1 AND page = $2`,
+			tournyId, page).Scan(&attempts)
+	})
+	return
+}
+
+// Forgets failures of a tournament's pages before page, now they've been imported
+func clearFailedPages(tournyId, page int) {
+	err := withTrans(func(tx *sql.Tx) error {
+		_, e := tx.Exec(`DELETE FROM scrape_failed_pages WHERE tournament_id = $1 AND page < $2`, tournyId, page)
+		return e
+	})
+	if err != nil {
+		fmt.Fprintf(out, "Failed to clear failed pages: %v\n", err)
+	}
+}
+
+// Stores any tournament ids we haven't seen before, returning how many were new
+func cacheTournamentIds(ids []int) (added int, err error) {
+	err = withTrans(func(tx *sql.Tx) error {
+		for _, id := range ids {
+			var known int
+			e := tx.QueryRow(`SELECT COUNT(*) FROM scrape_tournaments WHERE tournament_id = $1`, id).Scan(&known)
+			if e != nil {
+				return e
+			}
+			if known > 0 {
+				continue
+			}
+			if _, e = tx.Exec(`INSERT INTO scrape_tournaments (tournament_id, discovered) VALUES ($1, $2)`, id, time.Now()); e != nil {
+				return e
+			}
+			added++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return
+}
+
+// Returns the cached tournament ids between min & max, oldest first. A max of 0 means no upper bound.
+func loadTournamentIds(min, max int) (ids []int, err error) {
+	err = withTrans(func(tx *sql.Tx) error {
+		rows, e := tx.Query(`SELECT tournament_id FROM scrape_tournaments
+			WHERE tournament_id >= $1 AND ($2 = 0 OR tournament_id <= $2)
+			ORDER BY tournament_id`, min, max)
+		if e != nil {
+			return e
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var id int
+			if e := rows.Scan(&id); e != nil {
+				return e
+			}
+			ids = append(ids, id)
+		}
+		return rows.Err()
+	})
+	return
+}
+
+// Fetches a fighter by name. Dry runs keep their own copy of each fighter so rating changes
+// build up across matches without being written.
+func lookupFighter(name string) *spicerack.Fighter {
+	if report != nil {
+		if f := report.Fighter(name); f != nil {
+			return f
+		}
+	}
+
+	f, _ := repo.GetFighter(name)
+	if f == nil {
+		f = &spicerack.Fighter{Name: name}
+	}
+	if report != nil {
+		report.Track(name, f)
+	}
+	return f
+}
+
+// Whether a match has already been stored (or, in a dry run, would have been), either as a match
+// or just an outcome. Checked inside the importing transaction, so a match is only ever stored once
+// however often its page is imported.
+func matchStored(tx *sql.Tx, matchId int) (bool, error) {
+	if report != nil && report.Seen(matchId) {
+		return true, nil
+	}
+	var matches, outcomes int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM matches WHERE match_id = $1`, matchId).Scan(&matches); err != nil {
+		return false, err
+	}
+	err := tx.QueryRow(`SELECT COUNT(*) FROM match_outcomes WHERE match_id = $1`, matchId).Scan(&outcomes)
+	return matches+outcomes > 0, err
+}
+
+// Keeps the scrape lock's heartbeat fresh until stop is closed, so a run that takes hours isn't
+// mistaken for an abandoned one
+func heartbeat(holder string, stop <-chan bool) {
+	every := *lockTimeout / 4
+	if every <= 0 {
+		every = time.Minute
+	}
+	tick := time.NewTicker(every)
+	defer tick.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-tick.C:
+		}
+		var held bool
+		err := runTrans(func(tx *sql.Tx) (e error) {
+			held, e = lock.Beat(tx, holder)
+			return
+		}, true)
+		if err != nil {
+			fmt.Fprintf(out, "Failed to refresh scrape lock: %v\n", err)
+		} else if !held {
+			fmt.Fprintln(out, "Lost the scrape lock to another run, was -lock-timeout too short?")
+		}
+	}
+}
+
+// Match tiers used to be required, & backfilled matches were given today's. Postgres can just drop
+// the NOT NULL; SQLite can't, so there the table's copied into one without it.
+func allowUnknownTiers(backend string) error {
+	if backend != "sqlite" {
+		return runTrans(func(tx *sql.Tx) error {
+			_, e := tx.Exec(`ALTER TABLE match_details ALTER COLUMN red_tier DROP NOT NULL, ALTER COLUMN blue_tier DROP NOT NULL`)
+			return e
+		}, true)
+	}
+	return runTrans(func(tx *sql.Tx) error {
+		var ddl string
+		if e := tx.QueryRow(`SELECT sql FROM sqlite_master WHERE type = 'table' AND name = 'match_details'`).Scan(&ddl); e != nil {
+			return e
+		}
+		if !strings.Contains(ddl, "red_tier integer NOT NULL") {
+			return nil
+		}
+		for _, q := range []string{
+			`ALTER TABLE match_details RENAME TO match_details_required_tiers`,
+			CREATE_MATCH_DETAILS_SQL,
+			`INSERT INTO match_details (match_id, tournament_id, played, time_source, red_tier, blue_tier)
+				SELECT match_id, tournament_id, played, time_source, red_tier, blue_tier FROM match_details_required_tiers`,
+			`DROP TABLE match_details_required_tiers`,
+		} {
+			if _, e := tx.Exec(q); e != nil {
+				return e
+			}
+		}
+		return nil
+	}, true)
+}
+
+func releaseScrapeLock(holder string) {
+	err := runTrans(func(tx *sql.Tx) error { return lock.Release(tx, holder) }, true)
+	if err != nil {
+		fmt.Fprintf(out, "Failed to release scrape lock: %v\n", err)
+	}
+}
+
+// Records the start of a run in the history, returning its id
+func startRun(trigger string) (id int, err error) {
+	err = runTrans(func(tx *sql.Tx) error {
+		return tx.QueryRow(`INSERT INTO scrape_runs (trigger, host, started) VALUES ($1, $2, $3) RETURNING id`,
+			trigger, hostname(), time.Now()).Scan(&id)
+	}, true)
+	return
+}
+
+// Records how a run ended
+func finishRun(id int, runErr error) error {
+	if id == 0 {
+		return nil
+	}
+	msg := ""
+	if runErr != nil {
+		msg = runErr.Error()
+	}
+	return runTrans(func(tx *sql.Tx) error {
+		_, e := tx.Exec(`UPDATE scrape_runs SET finished = $2, succeeded = $3, error = $4 WHERE id = $1`,
+			id, time.Now(), runErr == nil, msg)
+		return e
+	}, true)
+}
+
+// Stamps the end of the run & works out whether it went ok, partially or not at all
+func (s *RunSummary) Finish(err error) {
+	s.Finished = time.Now()
+	s.Duration = s.Finished.Sub(s.Started).String()
+	switch {
+	case err != nil:
+		s.Status = "failed"
+		s.Error = err.Error()
+	case s.PagesFailed > 0 || s.MatchesErrored > 0:
+		s.Status = "partial"
+	default:
+		s.Status = "ok"
+	}
+}
+
+// One line summary, as relayed to the bot
+func (s *RunSummary) String() string {
+	counts := fmt.Sprintf("%d new matches, %d resolved, %d skipped, %d errored | %d pages, %d failed | %d new fighters, %d updated, %d detail changes, %d retired | %s",
+		s.MatchesNew, s.MatchesResolved, s.MatchesSkipped, s.MatchesErrored, s.PagesFetched, s.PagesFailed,
+		s.FightersAdded, s.FightersUpdated, s.DetailsChanged, s.FightersRetired, s.Duration)
+	if len(s.Retired) > 0 {
+		counts += fmt.Sprintf(" | retired: %s", strings.Join(s.Retired, ", "))
+	}
+	switch s.Status {
+	case "failed":
+		return fmt.Sprintf("Scrape failed: %s (%s)", s.Error, counts)
+	case "partial":
+		return fmt.Sprintf("Scrape finished with errors, bot information may be incomplete: %s", counts)
+	}
+	return fmt.Sprintf("Scrape complete, bot information is up to date: %s", counts)
+}
+
+func (s *RunSummary) Write(path string) error {
+	out, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, out, 0644)
+}
+
+// Records where & when a match happened & the fighters' tiers going into it. Fresh is set for
+// matches being stored for the first time; anything else only fills in missing details, or a site
+// provided time in place of a worse one. Tiers are as of the roster scrape, so they're only right
+// for matches a regular scrape is picking up as they happen; rebuilds & backfills leave them unknown.
+func saveDetails(tx *sql.Tx, pm *parser.Match, fresh bool) error {
+	var source string
+	err := tx.QueryRow(`SELECT COALESCE(time_source, '') FROM match_details WHERE match_id = $1`, pm.MatchId).Scan(&source)
+	exists := err == nil
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if exists && !fresh && (pm.Played.IsZero() || source == TIME_SITE) {
+		return nil
+	}
+
+	played, source, err := playedTime(tx, pm, fresh)
+	if err != nil {
+		return err
+	}
+	var playedVal interface{}
+	if !played.IsZero() {
+		playedVal = played
+	}
+	var redTier, blueTier interface{}
+	if fresh && !*saltTheEarth {
+		if redTier, err = tierOf(tx, pm.Red); err != nil {
+			return err
+		}
+		if blueTier, err = tierOf(tx, pm.Blue); err != nil {
+			return err
+		}
+	}
+
+	if exists {
+		_, err = tx.Exec(`UPDATE match_details SET tournament_id = $2, played = $3, time_source = $4,
+			red_tier = COALESCE($5, red_tier), blue_tier = COALESCE($6, blue_tier)
+			WHERE match_id = $1`, pm.MatchId, pm.TournamentId, playedVal, source, redTier, blueTier)
 	} else {
-		defer resp.Body.Close()
+		_, err = tx.Exec(`INSERT INTO match_details (match_id, tournament_id, played, time_source, red_tier, blue_tier)
+			VALUES ($1, $2, $3, $4, $5, $6)`, pm.MatchId, pm.TournamentId, playedVal, source, redTier, blueTier)
+	}
+	return err
+}
+
+// A fighter's tier as stored, read through tx so fighters the import added are seen; nil for an
+// unknown fighter or one without a tier
+func tierOf(tx *sql.Tx, name string) (interface{}, error) {
+	var tier int
+	err := tx.QueryRow(`SELECT tier FROM fighters WHERE name = $1`, name).Scan(&tier)
+	if err == sql.ErrNoRows || (err == nil && tier == 0) {
+		return nil, nil
+	}
+	return tier, err
+}
+
+// When a match was played: salty's own time if the profile can find one, otherwise now if a regular
+// scrape is picking it up fresh, otherwise (rebuilds & backfills) an estimate interpolated by match
+// id between the nearest matches with a better time.
+func playedTime(tx *sql.Tx, pm *parser.Match, fresh bool) (time.Time, string, error) {
+	if !pm.Played.IsZero() {
+		return pm.Played, TIME_SITE, nil
+	}
+	if fresh && !*saltTheEarth {
+		return time.Now(), TIME_SCRAPED, nil
+	}
+
+	var beforeId, afterId int
+	var before, after time.Time
+	err := tx.QueryRow(`SELECT match_id, played FROM match_details
+		WHERE match_id < $1 AND time_source IN ($2, $3) ORDER BY match_id DESC LIMIT 1`,
+		pm.MatchId, TIME_SITE, TIME_SCRAPED).Scan(&beforeId, &before)
+	if err != nil && err != sql.ErrNoRows {
+		return time.Time{}, "", err
+	}
+	err = tx.QueryRow(`SELECT match_id, played FROM match_details
+		WHERE match_id > $1 AND time_source IN ($2, $3) ORDER BY match_id ASC LIMIT 1`,
+		pm.MatchId, TIME_SITE, TIME_SCRAPED).Scan(&afterId, &after)
+	if err != nil && err != sql.ErrNoRows {
+		return time.Time{}, "", err
+	}
+
+	switch {
+	case beforeId != 0 && afterId != 0:
+		frac := float64(pm.MatchId-beforeId) / float64(afterId-beforeId)
+		return before.Add(time.Duration(frac * float64(after.Sub(before)))), TIME_ESTIMATED, nil
+	case beforeId != 0:
+		return before, TIME_ESTIMATED, nil
+	case afterId != 0:
+		return after, TIME_ESTIMATED, nil
 	}
+	return time.Time{}, "", nil
+}
+
+// Puts rows that wouldn't parse in the quarantine table, bumping the attempt count of any already there
+func quarantineRows(rejects []*quarantine.Row) {
+	for _, r := range rejects {
+		if err := withTrans(func(tx *sql.Tx) error { return quarantine.Add(tx, r) }); err != nil {
+			fmt.Fprintf(out, "--Failed to quarantine row from tournament #%d, page #%d: %v\n", r.TournamentId, r.Page, err)
+		}
+	}
+}
+
+// Bumps the attempt count & error of a row that's still in quarantine after being reprocessed
+func requarantine(id int, reason string) {
+	if err := withTrans(func(tx *sql.Tx) error { return quarantine.Bump(tx, id, reason) }); err != nil {
+		fmt.Fprintf(out, "--Failed to update quarantined row #%d: %v\n", id, err)
+	}
+}
+
+// Runs every quarantined row back through the parser. Rows that parse now are imported (oldest
+// match first) & released; the rest stay put with their latest error.
+func reprocessQuarantine() (err error) {
+	if names, err = aliases.Load(repo); err != nil {
+		return fmt.Errorf("Failed to load fighter aliases: %v", err)
+	}
+	var quarantined []*quarantine.Row
+	err = withTrans(func(tx *sql.Tx) (e error) {
+		quarantined, e = quarantine.Load(tx)
+		return
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to load quarantined rows: %v", err)
+	}
+	fmt.Fprintf(out, "Reprocessing %d quarantined rows\n", len(quarantined))
+
+	var matches []*parser.Match
+	var released []int
+	for _, q := range quarantined {
+		doc, pErr := gokogiri.ParseHtml([]byte("<html><body><table><tbody>" + q.Html + "</tbody></table></body></html>"))
+		if pErr != nil {
+			fmt.Fprintf(out, "--Row #%d: %v\n", q.Id, pErr)
+			requarantine(q.Id, pErr.Error())
+			continue
+		}
+		rows, _ := doc.Search(profile.TableRows)
+		if len(rows) == 0 {
+			fmt.Fprintf(out, "--Row #%d: no match row found with profile %s\n", q.Id, profile.Version)
+			requarantine(q.Id, fmt.Sprintf("no match row found with profile %s", profile.Version))
+			continue
+		}
+
+		parsed, rejects := parseRows(rows[:1], q.TournamentId, q.Page)
+		if len(rejects) > 0 {
+			// the row's html has been through the parser & back, so it's updated by id rather than matched on
+			requarantine(q.Id, rejects[0].Error)
+			continue
+		}
+		// pre-matchmaking rows parse to nothing, they're done with quarantine too
+		matches = append(matches, parsed...)
+		released = append(released, q.Id)
+	}
+
+	sort.Sort(parser.ByMatchId(matches))
+	if _, err := importMatches(matches); err != nil {
+		return fmt.Errorf("Failed to import quarantined rows, leaving them quarantined: %v", err)
+	}
+	for _, id := range released {
+		dErr := withTrans(func(tx *sql.Tx) error { return quarantine.Release(tx, id) })
+		if dErr != nil {
+			fmt.Fprintf(out, "--Failed to release row #%d from quarantine: %v\n", id, dErr)
+		}
+	}
+	fmt.Fprintf(out, "Released %d of %d quarantined rows\n", len(released), len(quarantined))
+	return nil
 }
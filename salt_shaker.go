@@ -1,13 +1,22 @@
 package main
 
 /*
-	IRC bot; reports current fight card w/ stats & hightower link in irc channel
+	IRC bot; reports current fight card w/ stats & hightower link in irc channels, on as many
+	networks as are configured. Each channel can have its own commands & announcements.
 	Commands:
 		`wl			 - Reports the page & credentials of detailed win/loss page for current fight card
 		`s 		     - Reports the current fight card
 		`s  p1 (,p2) - Reports a specific fight card for p1 and/or p2
-		`r			 - [Admin] Registers the bot with NickServ
-		`c [token]	 - [Admin] Sends a registration confirmation token to NickServ
+		`i  p1		 - Reports p1's compendium details; author, life, meter etc
+		`r			 - [Owner] Registers the bot with NickServ
+		`c [token]	 - [Owner] Sends a registration confirmation token to NickServ
+		`alias a = b - [Admin] Makes fighter name a an alias of fighter b
+		`unalias a	 - [Admin] Removes the fighter alias a
+		`merge a = b - [Admin] Merges fighter a into fighter b, leaving a as an alias
+		`reload	 - [Owner] Reloads the config without reconnecting (as does a SIGHUP)
+		`help [cmd]	 - Lists the commands you can use, or explains one
+	Roles (owner, admin, trusted & user) come from the config's roles section, by NickServ account
+	or hostmask; trusted users & up skip cooldowns.
 	TODO:
 */
 
@@ -16,22 +25,39 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/oguzbilgic/socketio"
+	"github.com/strider-/dreamer/aliases"
+	"github.com/strider-/dreamer/commands"
+	"github.com/strider-/dreamer/compendium"
+	"github.com/strider-/dreamer/config"
+	"github.com/strider-/dreamer/relay"
+	"github.com/strider-/dreamer/roles"
+	"github.com/strider-/dreamer/roster"
+	"github.com/strider-/dreamer/secrets"
+	"github.com/strider-/dreamer/storage"
 	"github.com/strider-/irc"
 	"io/ioutil"
 	"net/http"
 	"net/url"
 	"os"
 	"os/signal"
+	"regexp"
+	"sort"
 	"spicerack"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 )
 
 const (
-	MESSAGE_ENDPOINT string = "/shaker/bot/talk"
-	UNKNOWN_FIGHTER  string = "\x02\x0300New Challenger!\x03\x02"
-	BOT_ADMIN        string = "Lone_Strider"
+	UNKNOWN_FIGHTER string = "\x02\x0300New Challenger!\x03\x02"
+	COMMAND_PREFIX  string = "`"
+	// kinds of announcement, for a channel's announcements list
+	ANNOUNCE_CARDS   string = "cards"
+	ANNOUNCE_ODDS    string = "odds"
+	ANNOUNCE_WINNERS string = "winners"
+	ANNOUNCE_ALERTS  string = "alerts"
+	ANNOUNCE_RELAYS  string = "relays"
 	// string formats
 	LOG_TIME_FORMAT      string = "2006-01-02 15:04:05"
 	P1_NAME_FORMAT       string = "\x02\x0304%s\x03\x02"
@@ -49,82 +75,129 @@ const (
 	WL_MESSAGE           string = "%s [user: %s | pass: %s]"
 	COUNT_MESSAGE_GOOD   string = "There are approx %d untiered fighters."
 	COUNT_MESSAGE_BAD    string = "Sorry, looks like I fucked up (#callstrider)"
+	INFO_FORMAT          string = "%s | %s"
+	NO_INFO_MESSAGE      string = "No compendium details yet"
+	COOLDOWN_MESSAGE     string = "Easy there! %s can be used again in %ds."
 
 	UPSET_FACTOR float64 = 2.0
 )
 
-type Settings struct {
-	DbName, DbUser, DbPass      string
-	Server, Channel, Nick, Pass string
-	BotEmail                    string
-	TheShiznit                  string
-	Websocket                   string
-	WlAddr, WlUser, WlPass      string
-	GoogleApiKey                string
-	Pushover                    map[string]interface{}
+// Message templates that can be replaced in the config's formats section, by name
+var defaultFormats = map[string]string{
+	"unknown_fighter": UNKNOWN_FIGHTER,
+	"p1_name":         P1_NAME_FORMAT,
+	"p2_name":         P2_NAME_FORMAT,
+	"rematch":         REMATCH_FORMAT,
+	"rematch_trade":   REMATCH_TRADE_FORMAT,
+	"winner":          WINNER_FORMAT,
+	"upset_winner":    UPSET_WINNER_FORMAT,
+	"vs":              VS_FORMAT_NO_LINK,
+	"solo":            SOLO_FORMAT_NO_LINK,
+	"wl":              WL_MESSAGE,
+	"count":           COUNT_MESSAGE_GOOD,
+	"oops":            COUNT_MESSAGE_BAD,
+	"info":            INFO_FORMAT,
+	"no_info":         NO_INFO_MESSAGE,
+	"cooldown":        COOLDOWN_MESSAGE,
 }
 
+var announcementKinds = []string{ANNOUNCE_CARDS, ANNOUNCE_ODDS, ANNOUNCE_WINNERS, ANNOUNCE_ALERTS, ANNOUNCE_RELAYS}
+
+var verbRx = regexp.MustCompile(`%[-+# 0]*[0-9]*(\.[0-9]+)?[a-zA-Z%]`)
+
 type Options struct {
 	LooseSearch bool
 }
 
 var (
-	settings     *Settings = &Settings{}
-	client       *irc.Client
-	lastAnnounce time.Time
+	loaded       *config.Config = &config.Config{}
+	loadedMu     sync.RWMutex
+	reloadMu     sync.Mutex
+	clients      = make(map[string]*irc.Client) // by network name
+	clientsMu    sync.Mutex
+	started      bool
+	connected    sync.WaitGroup
+	pollOnce     sync.Once
+	router       *commands.Router
+	roleTable    *roles.Table    = &roles.Table{}
+	verifier     *roles.Verifier = roles.NewVerifier(func(network, msg string) { send(network, "NickServ", msg) })
+	db           storage.Store
 	shouldNotify bool        = true
 	logChannel   chan string = make(chan string)
 )
 
 func main() {
+	// commands come first, the config's checked against them. Nothing's listening for logs yet.
+	if err := registerCommands(); err != nil {
+		fmt.Printf("Couldn't add command: %v - Quitting.\n", err)
+		os.Exit(1)
+	}
+
+	// `salt_shaker config check` only reports on the config
+	if config.IsCheckCommand(os.Args[1:]) {
+		if !config.Check(os.Stdout, config.Bot, checkFormats, checkCooldowns, checkNetworks, checkRoles) {
+			os.Exit(1)
+		}
+		return
+	}
+
 	// thread-safe message logging
 	go listenForLogs()
 
 	// catching interrupt and terminate signals
 	sigs := make(chan os.Signal, 1)
-	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT)
+	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
 	go catchSignals(sigs)
 
 	// loading from global config
 	log("PID: %d\n", os.Getpid())
 	log("Loading Configuration")
-	conf, err := spicerack.GofigFromEnv("ME_CONF")
+	if err := reload(); err != nil {
+		log("%v - Quitting.", err)
+		os.Exit(1)
+	}
+
+	var err error
+	db, err = storage.Open(settings().DbBackend, settings().DbUser, settings().DbPass, settings().DbName)
 	if err != nil {
-		log("%v -  Quitting.", err)
+		log("Failed to open storage: %v - Quitting.", err)
 		os.Exit(1)
 	}
+	defer db.Close()
 
-	// reading from config & initialzing IRC client
-	conf.Struct("salty", settings)
-	settings.Pushover, _ = conf.Map("pushover")
-	client = irc.NewClient(settings.Server, settings.Nick, false)
+	// fighter lookups go through the alias table, make sure it's there
+	if err := aliases.CreateTable(db); err != nil {
+		log("Failed to create alias table: %v", err)
+	}
+	if err := compendium.CreateTables(db); err != nil {
+		log("Failed to create fighter detail tables: %v", err)
+	}
+	if err := roster.CreateTable(db); err != nil {
+		log("Failed to create fighter status table: %v", err)
+	}
 
 	// If the bot crashes, send a notification
 	defer func() {
 		if r := recover(); r != nil {
 			log("Panic! Sending notification: %v", r)
-			msg := fmt.Sprintf("%s is down! (%v)", settings.Nick, r)
+			msg := secrets.Redact(fmt.Sprintf("%s is down! (%v)", botName(), r))
 			notify(msg)
 		}
 	}()
 
-	// hookup irc command handling functions
-	client.HandleCommand(irc.RPL_WELCOME, registerAndJoin)
-	client.HandleCommand(irc.CMD_PRIVMSG, manualFightCard)
-	client.HandleCommand(irc.CMD_PRIVMSG, getSpecificFighters)
-	client.HandleCommand(irc.CMD_PRIVMSG, showWLInfo)
-	client.HandleCommand(irc.CMD_PRIVMSG, getUntieredCount)
-	client.HandleCommand(irc.CMD_PRIVMSG, nickServ)
-
-	// connect to IRC & wait indefinitely, and listen for HTTP posts
-	// to relay to the channel
-	log("Connecting to IRC...")
-	client.Connect()
+	// connect to every network & wait indefinitely, and listen for HTTP posts
+	// to relay to the channels
+	clientsMu.Lock()
+	started = true
+	clientsMu.Unlock()
+	for _, n := range settings().Networks {
+		connect(n)
+	}
 	listenForRelays()
-	client.Wait()
+	connected.Wait()
 
 	if shouldNotify {
-		notify(fmt.Sprintf("%s has unexpectedly stopped!", settings.Nick))
+		notify(fmt.Sprintf("%s has unexpectedly stopped!", botName()))
 	}
 }
 
@@ -133,11 +206,209 @@ func log(msg string, args ...interface{}) {
 	logChannel <- fmt.Sprintf("[%s] %s", time.Now().Format(LOG_TIME_FORMAT), fmt.Sprintf(msg, args...))
 }
 
+// The config currently in use
+func settings() *config.Config {
+	loadedMu.RLock()
+	defer loadedMu.RUnlock()
+	return loaded
+}
+
+// Who has which role, going by the config currently in use
+func currentRoles() *roles.Table {
+	loadedMu.RLock()
+	defer loadedMu.RUnlock()
+	return roleTable
+}
+
+// The roles section as a table; the old salty.admin still counts, as an owner by account
+func rolesFrom(c *config.Config) (*roles.Table, config.Problems) {
+	t, problems := roles.NewTable(c.Roles)
+	if c.Admin != "" {
+		t.Add(roles.Owner, roles.ACCOUNT_PREFIX+c.Admin)
+	}
+	return t, problems
+}
+
+// The roles section has to name real roles, with entries that make sense
+func checkRoles(c *config.Config) config.Problems {
+	_, problems := rolesFrom(c)
+	return problems
+}
+
+// Works out the role of whoever sent m on network. Their hostmask's role is used if that's enough,
+// otherwise NickServ is asked which account they're logged in as; nicks alone are never trusted.
+// Admin & owner commands always get a fresh answer, someone else could have the nick by now.
+func roleOf(network string, m *irc.Message, need roles.Role, then func(role roles.Role)) {
+	t := currentRoles()
+	role := t.ByMask(fmt.Sprintf("%s!%s@%s", m.Nick, m.User, m.Host))
+	if role >= need || !t.HasAccounts() {
+		// what NickServ said recently still counts, it just isn't asked again
+		if account, ok := verifier.Known(network, m.Nick); ok && t.ByAccount(account) > role {
+			role = t.ByAccount(account)
+		}
+		then(role)
+		return
+	}
+	n, _ := settings().Network(network)
+	verifier.Account(network, m.Nick, n.AccountCheck, need >= roles.Admin, func(account string) {
+		if byAccount := t.ByAccount(account); byAccount > role {
+			role = byAccount
+		}
+		then(role)
+	})
+}
+
+// A message template, from the config's formats section if it's been replaced there
+func format(name string) string {
+	if f, ok := settings().Formats[name]; ok {
+		return f
+	}
+	return defaultFormats[name]
+}
+
+// Templates in the formats section have to be ones the bot knows, taking the same values in the same order
+func checkFormats(c *config.Config) (problems config.Problems) {
+	for _, name := range sortedNames(c.Formats) {
+		def, ok := defaultFormats[name]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("formats.%s isn't a message the bot sends", name))
+		} else if got, want := verbRx.FindAllString(c.Formats[name], -1), verbRx.FindAllString(def, -1); strings.Join(got, "") != strings.Join(want, "") {
+			problems = append(problems, fmt.Sprintf("formats.%s should have %s in it, in that order", name, strings.Join(want, " ")))
+		}
+	}
+	return
+}
+
+// Cooldowns in the config have to be for a command, by its name rather than an alias
+func checkCooldowns(c *config.Config) (problems config.Problems) {
+	for key := range c.Cooldowns {
+		name := strings.SplitN(key, ".", 2)[0]
+		if cmd, ok := router.Lookup(name); name != "*" && (!ok || cmd.Name != name) {
+			problems = append(problems, fmt.Sprintf("cooldowns.%s isn't for a command the bot has", key))
+		}
+	}
+	sort.Strings(problems)
+	return
+}
+
+// A command's cooldowns, as declared unless the config's cooldowns section says otherwise;
+// a command's own entries there beat the * ones
+func cooldownFor(cmd *commands.Command) commands.Cooldown {
+	cd := cmd.Cooldown
+	overrides := settings().Cooldowns
+	for _, name := range []string{"*", cmd.Name} {
+		if d, ok := overrides[name]; ok {
+			cd.Command = d
+		}
+		if d, ok := overrides[name+".user"]; ok {
+			cd.User = d
+		}
+		if d, ok := overrides[name+".channel"]; ok {
+			cd.Channel = d
+		}
+	}
+	return cd
+}
+
+// Lets whoever hit a cooldown know privately, if the config says to
+func cooldownNotice(c *commands.Context, wait time.Duration) {
+	if settings().CooldownNotice {
+		send(c.Network, c.Msg.Nick, fmt.Sprintf(format("cooldown"), COMMAND_PREFIX+c.Cmd.Name, int((wait+time.Second-1)/time.Second)))
+	}
+}
+
+// Channels can only turn on commands & announcements the bot has
+func checkNetworks(c *config.Config) (problems config.Problems) {
+	for _, n := range c.Networks {
+		for _, ch := range n.Channels {
+			prefix := fmt.Sprintf("networks.%s.channels.%s", n.Name, ch.Name)
+			for _, name := range ch.Commands {
+				if cmd, ok := router.Lookup(name); !ok || cmd.Name != name {
+					problems = append(problems, fmt.Sprintf("%s.commands has %s, which isn't a command the bot has", prefix, name))
+				}
+			}
+			for _, kind := range ch.Announcements {
+				if !isAnnouncementKind(kind) {
+					problems = append(problems, fmt.Sprintf("%s.announcements has %s, it should be one of %s", prefix, kind, strings.Join(announcementKinds, ", ")))
+				}
+			}
+		}
+	}
+	return
+}
+
+func isAnnouncementKind(kind string) bool {
+	for _, k := range announcementKinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedNames(m map[string]string) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// (Re)loads the config, applying what changed without reconnecting to IRC: networks & channels
+// are joined & left to match, while templates, roles, wl details & pushover are simply
+// read from the new config from here on. A config with problems is turned away, keeping the current one.
+func reload() error {
+	// one at a time, a SIGHUP & `reload can come in together
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	next, err := config.Load(config.Bot)
+	var table *roles.Table
+	if err == nil {
+		problems := append(checkFormats(next), checkCooldowns(next)...)
+		problems = append(problems, checkNetworks(next)...)
+		var more config.Problems
+		table, more = rolesFrom(next)
+		if problems = append(problems, more...); len(problems) > 0 {
+			err = problems
+		}
+	}
+	if err != nil {
+		return err
+	}
+	for _, warning := range next.Warnings {
+		log("Warning: %s", warning)
+	}
+
+	loadedMu.Lock()
+	prev := loaded
+	loaded, roleTable = next, table
+	loadedMu.Unlock()
+
+	clientsMu.Lock()
+	running := started
+	clientsMu.Unlock()
+	if !running {
+		// first load, nothing's connected yet
+		return nil
+	}
+	syncNetworks(prev, next)
+	if prev.Websocket != next.Websocket {
+		log("Websocket changed, it'll be used the next time it reconnects.")
+	}
+	if prev.DbBackend != next.DbBackend || prev.DbName != next.DbName || prev.DbUser != next.DbUser || prev.DbPass != next.DbPass {
+		log("Database settings changed, that won't take effect until a restart.")
+	}
+	log("Configuration reloaded.")
+	return nil
+}
+
 // go routine for logging messages to stdout
 func listenForLogs() {
 	for {
 		if msg, ok := <-logChannel; ok {
-			fmt.Println(strings.TrimRight(msg, "\n"))
+			fmt.Println(secrets.Redact(strings.TrimRight(msg, "\n")))
 		} else {
 			break
 		}
@@ -145,102 +416,379 @@ func listenForLogs() {
 }
 
 // go routine waiting for an interrupt or terminate signal, so
-// the client can be closed gracefully
+// the client can be closed gracefully. A hangup reloads the config instead.
 func catchSignals(sigs <-chan os.Signal) {
 	for {
 		s := <-sigs
+		if s == syscall.SIGHUP {
+			log("Recieved OS Signal '%v', reloading configuration.", s)
+			if err := reload(); err != nil {
+				log("Reload failed, keeping the current configuration: %v", err)
+			}
+			continue
+		}
 		shouldNotify = false
 		log("Recieved OS Signal '%v', closing gracefully.", s)
-		client.Quit("Going down for an update, brb")
+		for _, c := range allClients() {
+			c.Quit("Going down for an update, brb")
+		}
 		break
 	}
 }
 
-// when connected to the server, identify w/ nickserv, join CHANNEL and start polling salty
-func registerAndJoin(m *irc.Message) {
-	log("Connected to IRC, registering nick.")
-	client.Privmsg("NickServ", fmt.Sprintf("identify %s", settings.Pass))
+// Connects to a network; its channels are joined once it's connected
+func connect(n config.Network) {
+	c := irc.NewClient(n.Server, n.Nick, false)
+	name := n.Name
+	c.HandleCommand(irc.RPL_WELCOME, func(m *irc.Message) { registerAndJoin(name) })
+	c.HandleCommand(irc.CMD_PRIVMSG, func(m *irc.Message) { handlePrivmsg(name, m) })
+	c.HandleCommand(irc.CMD_NOTICE, func(m *irc.Message) { handleNotice(name, m) })
+	// whoever was logged in under a nick might not be anymore
+	for _, cmd := range []string{"NICK", "QUIT", "PART"} {
+		c.HandleCommand(cmd, func(m *irc.Message) { verifier.Forget(name, m.Nick) })
+	}
 
-	log("Joining %s", settings.Channel)
-	client.Join(settings.Channel)
+	clientsMu.Lock()
+	clients[name] = c
+	clientsMu.Unlock()
 
-	log("Starting websocket loop.")
-	go pollSalty()
+	// counted before connecting, so main never sees every connection gone while this one's starting
+	connected.Add(1)
+	log("Connecting to %s (%s)...", name, n.Server)
+	c.Connect()
+	go func() {
+		defer connected.Done()
+		c.Wait()
+	}()
 }
 
-// handles `wl command to announce detailed win/loss page w/ user&pass.
-func showWLInfo(m *irc.Message) {
-	if m.IsChannelMsg() && m.Parameters[0] == settings.Channel && m.Trail == "`wl" {
-		msg := fmt.Sprintf(WL_MESSAGE, settings.WlAddr, settings.WlUser, settings.WlPass)
-		client.Privmsg(settings.Channel, msg)
+// Passes NickServ's answers on to the verifier. They decide who's an owner or admin, so they have to
+// come from the network's services mask; anyone can take the nick NickServ if services are down.
+func handleNotice(network string, m *irc.Message) {
+	if !strings.EqualFold(m.Nick, "NickServ") {
+		return
 	}
+	n, _ := settings().Network(network)
+	from := fmt.Sprintf("%s!%s@%s", m.Nick, m.User, m.Host)
+	if !roles.MatchMask(n.Services, from) {
+		log("Ignoring a notice from %s on %s, NickServ there is %s", from, network, n.Services)
+		return
+	}
+	verifier.Answer(network, m.Trail)
 }
 
-func getUntieredCount(m *irc.Message) {
-	if m.IsChannelMsg() && m.Parameters[0] == settings.Channel && m.Trail == "`u" {
-		db := spicerack.Db(settings.DbUser, settings.DbPass, settings.DbName)
-		defer db.Close()
+// Leaves a network for good
+func disconnect(network, reason string) {
+	clientsMu.Lock()
+	c, ok := clients[network]
+	delete(clients, network)
+	clientsMu.Unlock()
+	if ok {
+		c.Quit(reason)
+	}
+}
 
-		if count, err := db.GetUntieredCount(); err != nil {
-			client.Privmsg(settings.Channel, COUNT_MESSAGE_BAD)
-		} else {
-			client.Privmsg(settings.Channel, fmt.Sprintf(COUNT_MESSAGE_GOOD, count))
+// Brings the connections in line with a reloaded config; new networks are connected, removed ones
+// left, & channels joined & left to match. A network's new server or nick needs a restart.
+func syncNetworks(prev, next *config.Config) {
+	for _, n := range next.Networks {
+		old, ok := prev.Network(n.Name)
+		if !ok {
+			connect(n)
+			continue
+		}
+		if old.Server != n.Server || old.Nick != n.Nick || old.Pass != n.Pass {
+			log("%s's server, nick or password changed, that won't take effect until a restart.", n.Name)
+		}
+		c := clientFor(n.Name)
+		if c == nil {
+			continue
+		}
+		for _, ch := range n.Channels {
+			if _, ok := old.Channel(ch.Name); !ok {
+				log("Joining %s on %s", ch.Name, n.Name)
+				c.Join(ch.Name)
+			}
+		}
+		for _, ch := range old.Channels {
+			if _, ok := n.Channel(ch.Name); !ok {
+				log("Leaving %s on %s", ch.Name, n.Name)
+				c.Part(ch.Name)
+			}
+		}
+	}
+	// leaving every network is shutting the bot down on purpose, not it falling over
+	if len(next.Networks) == 0 {
+		shouldNotify = false
+	}
+	for _, n := range prev.Networks {
+		if _, ok := next.Network(n.Name); !ok {
+			log("Leaving %s", n.Name)
+			disconnect(n.Name, "Leaving this network, bye")
+		}
+	}
+}
+
+func clientFor(network string) *irc.Client {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	return clients[network]
+}
+
+func allClients() []*irc.Client {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	list := make([]*irc.Client, 0, len(clients))
+	for _, c := range clients {
+		list = append(list, c)
+	}
+	return list
+}
+
+// Says msg to a channel or nick on a network, if the bot's on it
+func send(network, target, msg string) {
+	if c := clientFor(network); c != nil {
+		c.Privmsg(target, msg)
+	}
+}
+
+// Says msg in every channel taking this kind of announcement
+func announce(kind, msg string) {
+	for _, n := range settings().Networks {
+		for _, ch := range n.Channels {
+			if ch.Announces(kind) {
+				send(n.Name, ch.Name, msg)
+			}
 		}
 	}
 }
 
-// handles `s commands to get the current fight card
-func manualFightCard(m *irc.Message) {
-	if m.IsChannelMsg() && m.Parameters[0] == settings.Channel && m.Trail == "`s" {
-		if data, err := spicerack.GetSecretData(settings.TheShiznit); err == nil {
-			announceFightCard(data, nil)
+// Something that says each message it's given in every channel taking this kind of announcement
+func announcer(kind string) func(msg string) {
+	return func(msg string) { announce(kind, msg) }
+}
+
+// The bot's nick on its first network, for notifications
+func botName() string {
+	if n := settings().Networks; len(n) > 0 {
+		return n[0].Nick
+	}
+	return "salt_shaker"
+}
+
+// when connected to a network, identify w/ nickserv, join its channels and start polling salty
+func registerAndJoin(network string) {
+	n, ok := settings().Network(network)
+	c := clientFor(network)
+	if !ok || c == nil {
+		return
+	}
+	verifier.Reset(network)
+	if n.Pass != "" {
+		log("Connected to %s, registering nick.", network)
+		c.Privmsg("NickServ", fmt.Sprintf("identify %s", n.Pass))
+	} else {
+		log("Connected to %s, no pass to identify with.", network)
+	}
+
+	for _, ch := range n.Channels {
+		log("Joining %s on %s", ch.Name, network)
+		c.Join(ch.Name)
+	}
+
+	// one loop feeds every network
+	pollOnce.Do(func() {
+		log("Starting websocket loop.")
+		go pollSalty()
+	})
+}
+
+// The bot's commands; `help lists them for whoever asks. Fails on a name or alias that's taken twice.
+func registerCommands() error {
+	router = commands.NewRouter(COMMAND_PREFIX, send)
+	router.RoleOf = roleOf
+	router.Enabled = func(network, channel string, cmd *commands.Command) bool {
+		n, ok := settings().Network(network)
+		if !ok {
+			return false
+		}
+		ch, ok := n.Channel(channel)
+		return ok && ch.Allows(cmd.Name)
+	}
+	router.CooldownOf = cooldownFor
+	router.OnCooldown = cooldownNotice
+	lookup := commands.Cooldown{User: 10 * time.Second, Channel: 3 * time.Second}
+
+	cmds := []*commands.Command{
+		{Name: "s", Aliases: []string{"card"}, Usage: "[p1 [, p2]]", Help: "Reports the current fight card, or a specific one for p1 and/or p2",
+			Where: commands.InChannel, Split: ",", MaxArgs: 2, Cooldown: lookup, Run: fightCard},
+		{Name: "i", Aliases: []string{"info"}, Usage: "p1", Help: "Reports p1's compendium details; author, life, meter etc",
+			Where: commands.InChannel, MinArgs: 1, MaxArgs: 1, Cooldown: lookup, Run: getFighterInfo},
+		{Name: "wl", Help: "Reports the page & credentials of the detailed win/loss page for the current fight card",
+			Where: commands.InChannel, Cooldown: commands.Cooldown{Channel: 10 * time.Second}, Run: showWLInfo},
+		{Name: "u", Aliases: []string{"untiered"}, Help: "Reports roughly how many fighters are still untiered",
+			Where: commands.InChannel, Cooldown: commands.Cooldown{Channel: 10 * time.Second}, Run: getUntieredCount},
+		{Name: "r", Aliases: []string{"register"}, Help: "Registers the bot with NickServ",
+			Role: roles.Owner, Where: commands.Anywhere, Run: registerNick},
+		{Name: "c", Aliases: []string{"confirm"}, Usage: "token", Help: "Sends a registration confirmation token to NickServ",
+			Role: roles.Owner, Where: commands.Anywhere, Split: " ", MinArgs: 1, MaxArgs: 1, Run: confirmNick},
+		{Name: "alias", Usage: "alternate name = fighter name", Help: "Makes one fighter name an alias of another",
+			Role: roles.Admin, Where: commands.Anywhere, Split: "=", MinArgs: 2, MaxArgs: 2, Run: addAlias},
+		{Name: "unalias", Usage: "alternate name", Help: "Removes a fighter alias",
+			Role: roles.Admin, Where: commands.Anywhere, MinArgs: 1, MaxArgs: 1, Run: removeAlias},
+		{Name: "merge", Usage: "old fighter = fighter to keep", Help: "Merges one fighter into another, leaving the old name as an alias",
+			Role: roles.Admin, Where: commands.Anywhere, Split: "=", MinArgs: 2, MaxArgs: 2, Run: mergeFighters},
+		{Name: "reload", Help: "Reloads the config without reconnecting, same as a SIGHUP",
+			Role: roles.Owner, Where: commands.Anywhere, Run: reloadConfig},
+	}
+	for _, c := range cmds {
+		if err := router.Add(c); err != nil {
+			return err
 		}
 	}
+	return nil
+}
+
+// runs commands, & logs any other private messages the bot gets, why not?
+func handlePrivmsg(network string, m *irc.Message) {
+	if !router.Handle(network, m) && !m.IsChannelMsg() {
+		log("<%s@%s>: %s", m.Nick, network, m.Trail)
+	}
+}
+
+// handles `wl command to announce detailed win/loss page w/ user&pass.
+func showWLInfo(c *commands.Context) {
+	c.Replyf(format("wl"), settings().WlAddr, settings().WlUser, settings().WlPass)
+}
+
+func getUntieredCount(c *commands.Context) {
+	// retired fighters are never getting a tier, they don't count
+	count, err := db.GetUntieredCount()
+	var retired int
+	if err == nil {
+		retired, err = roster.RetiredUntiered(db)
+	}
+	if err != nil {
+		c.Reply(format("oops"))
+	} else {
+		c.Replyf(format("count"), count-retired)
+	}
 }
 
-// handles `s p1 [, p2]
-func getSpecificFighters(m *irc.Message) {
-	if m.IsChannelMsg() && m.Parameters[0] == settings.Channel && strings.HasPrefix(m.Trail, "`s ") {
-		data := createFightCard(m.Trail[3:])
-		opts := &Options{LooseSearch: true}
-		announceFightCard(data, opts)
+// handles `s to get the current fight card, & `s p1 [, p2] for a specific one
+func fightCard(c *commands.Context) {
+	if len(c.Args) == 0 {
+		if data, err := spicerack.GetSecretData(settings().TheShiznit); err == nil {
+			announceFightCard(data, nil, c.Reply)
+		}
+		return
 	}
+	data := createFightCard(c.Args)
+	opts := &Options{LooseSearch: true}
+	announceFightCard(data, opts, c.Reply)
+}
+
+// handles `i command to report a fighter's compendium details
+func getFighterInfo(c *commands.Context) {
+	names, err := aliases.Load(db)
+	if err != nil {
+		log("Failed to load fighter aliases: %v", err)
+	}
+	name := c.Args[0]
+	f, _, err := db.SearchFighters(names.Resolve(name), "")
+	if err != nil || f == nil || f.Id == 0 {
+		c.Replyf(format("info"), formatFighterName(nil, name, format("p1_name")), format("unknown_fighter"))
+		return
+	}
+
+	attrs, err := compendium.Load(db, f.Id)
+	if err != nil {
+		log("Failed to load details for '%s': %v", f.Name, err)
+		c.Reply(format("oops"))
+		return
+	}
+	details := make([]string, 0, len(attrs))
+	for _, attr := range compendium.Names(attrs) {
+		details = append(details, fmt.Sprintf("%s: %s", attr, attrs[attr]))
+	}
+	info := format("no_info")
+	if len(details) > 0 {
+		info = strings.Join(details, " | ")
+	}
+	c.Replyf(format("info"), formatFighterName(f, f.Name, format("p1_name")), info)
 }
 
 // generates a 'fake' fight card for the purposes of reporting specific requested fighters
-func createFightCard(str string) *spicerack.FightCard {
-	fighters := strings.Split(str, ",")
+func createFightCard(fighters []string) *spicerack.FightCard {
 	fc := &spicerack.FightCard{
-		RedName: strings.Trim(fighters[0], " "),
+		RedName: fighters[0],
 	}
 
 	if len(fighters) > 1 {
-		fc.BlueName = strings.Trim(fighters[1], " ")
+		fc.BlueName = fighters[1]
 	}
 
 	return fc
 }
 
-// NickServ registration/confirmation
-func nickServ(m *irc.Message) {
-	if m.Nick == BOT_ADMIN && strings.HasPrefix(m.Trail, "`") {
-		switch m.Trail[1:2] {
-		case "r":
-			client.Privmsg("NickServ", fmt.Sprintf("register %s %s", settings.Pass, settings.BotEmail))
-		case "c":
-			token := m.Trail[3:]
-			client.Privmsg("NickServ", fmt.Sprintf("confirm %s", token))
-		}
-	} else if !m.IsChannelMsg() {
-		// log any private messages the bot gets, why not?
-		log("<%s>: %s", m.Nick, m.Trail)
+// NickServ registration, on whichever network the command came from
+func registerNick(c *commands.Context) {
+	n, _ := settings().Network(c.Network)
+	if n.Pass == "" {
+		c.Reply("There's no pass for this network in the config to register with")
+		return
+	}
+	send(c.Network, "NickServ", fmt.Sprintf("register %s %s", n.Pass, settings().BotEmail))
+}
+
+// NickServ registration confirmation
+func confirmNick(c *commands.Context) {
+	send(c.Network, "NickServ", fmt.Sprintf("confirm %s", c.Args[0]))
+}
+
+// handles `alias
+func addAlias(c *commands.Context) {
+	if err := aliases.Add(db, c.Args[0], c.Args[1]); err != nil {
+		c.ReplyErr("Couldn't add alias", err)
+	} else {
+		c.Replyf("'%s' is now an alias of '%s'", c.Args[0], c.Args[1])
+	}
+}
+
+// handles `unalias
+func removeAlias(c *commands.Context) {
+	if err := aliases.Remove(db, c.Args[0]); err != nil {
+		c.ReplyErr("Couldn't remove alias", err)
+	} else {
+		c.Replyf("Removed alias '%s'", c.Args[0])
+	}
+}
+
+// handles `merge
+func mergeFighters(c *commands.Context) {
+	if sqlDb, ok := db.(storage.SQLStore); !ok {
+		c.ReplyErr("Couldn't merge", storage.ErrNotSQL)
+	} else if dropped, err := aliases.Merge(sqlDb, c.Args[0], c.Args[1]); err != nil {
+		c.ReplyErr("Couldn't merge", err)
+	} else {
+		c.Replyf("Merged '%s' into '%s', dropping %d matches between them; '%s' keeps its rating", c.Args[0], c.Args[1], dropped, c.Args[1])
+	}
+}
+
+// handles `reload, same as a SIGHUP
+func reloadConfig(c *commands.Context) {
+	if err := reload(); err != nil {
+		log("Reload failed, keeping the current configuration: %v", err)
+		c.Reply("Config has problems, kept the current one (details in the log)")
+	} else {
+		c.Reply("Config reloaded")
 	}
 }
 
 // websocket loop
 func pollSalty() {
 	for {
-		socket, err := socketio.DialAndConnect(settings.Websocket, "", "")
+		socket, err := socketio.DialAndConnect(settings().Websocket, "", "")
 		if err != nil {
 			log("Failed to connect to websocket: %v. Trying again in 10 sec.", err)
 			time.Sleep(time.Second * 10)
@@ -256,16 +804,14 @@ func pollSalty() {
 				break
 			}
 
-			data, err := spicerack.GetSecretData(settings.TheShiznit)
+			data, err := spicerack.GetSecretData(settings().TheShiznit)
 			if err != nil {
 				log("%v", err)
 			} else {
 				if lastStatus != data.Status {
-					// reset the cooldown so we always get an up-to-date triggered annoucement
-					lastAnnounce = time.Now().Add(time.Second * -5)
-
+					// automatic announcements aren't held to any cooldown, so a busy channel can't hold them up
 					if data.TakingBets() {
-						announceFightCard(data, nil)
+						announceFightCard(data, nil, announcer(ANNOUNCE_CARDS))
 					} else if data.InProgress() {
 						announceOdds(data)
 					} else if data.WeHaveAWinner() {
@@ -277,82 +823,83 @@ func pollSalty() {
 
 				if data.Alert != "" && lastAlert != data.Alert {
 					lastAlert = data.Alert
-					client.Privmsg(settings.Channel, fmt.Sprintf("Salty Alert: %s", data.Alert))
+					announce(ANNOUNCE_ALERTS, fmt.Sprintf("Salty Alert: %s", data.Alert))
 				}
 			}
 		}
 	}
 }
 
-// sends fight card / fighter stats information to IRC
-func announceFightCard(data *spicerack.FightCard, opts *Options) {
+// sends fight card / fighter stats information to IRC, through say
+func announceFightCard(data *spicerack.FightCard, opts *Options, say func(msg string)) {
 	if len(data.RedName) == 0 && len(data.BlueName) == 0 {
 		return // Nothing to announce!
 	}
 
-	diff := time.Now().Sub(lastAnnounce)
-	if diff.Seconds() >= 3 {
-		lastAnnounce = time.Now()
-		db := spicerack.Db(settings.DbUser, settings.DbPass, settings.DbName)
-		defer db.Close()
-		var red, blue *spicerack.Fighter
-		var e error
+	var red, blue *spicerack.Fighter
+	var e error
 
-		if opts != nil && opts.LooseSearch {
-			red, blue, e = db.SearchFighters(data.RedName, data.BlueName)
-		} else {
-			red, blue, e = db.GetFighters(data.RedName, data.BlueName)
-		}
-
-		if e == nil {
-			p1f := formatFighterName(red, data.RedName, P1_NAME_FORMAT)
-			p2f := formatFighterName(blue, data.BlueName, P2_NAME_FORMAT)
-			p1stats := formatFighterStats(red)
-			p2stats := formatFighterStats(blue)
-			// ht := getHightowerUrl(data.RedName, data.BlueName)
-
-			var card string = ""
-			if len(data.RedName) > 0 && len(data.BlueName) > 0 {
-				card = fmt.Sprintf(VS_FORMAT_NO_LINK, p1f, p1stats, p2f, p2stats /*, ht*/)
-			} else if len(data.RedName) > 0 {
-				card = fmt.Sprintf(SOLO_FORMAT_NO_LINK, p1f, p1stats /*, ht*/)
-			} else if len(data.BlueName) > 0 {
-				card = fmt.Sprintf(SOLO_FORMAT_NO_LINK, p2f, p2stats /*, ht*/)
-			}
+	// renamed & mangled names are looked up under the name the fighter is stored as
+	names, err := aliases.Load(db)
+	if err != nil {
+		log("Failed to load fighter aliases: %v", err)
+	}
+	redName, blueName := names.Resolve(data.RedName), names.Resolve(data.BlueName)
 
-			client.Privmsg(settings.Channel, card)
-
-			state, err := db.GetRematchState(red, blue)
-			if err == nil {
-				switch state {
-				case spicerack.TradedWins:
-					client.Privmsg(settings.Channel, fmt.Sprintf(REMATCH_TRADE_FORMAT, p1f, p2f))
-				case spicerack.RedBeatBlue:
-					client.Privmsg(settings.Channel, fmt.Sprintf(REMATCH_FORMAT, p1f, p2f))
-				case spicerack.BlueBeatRed:
-					client.Privmsg(settings.Channel, fmt.Sprintf(REMATCH_FORMAT, p2f, p1f))
-				}
-			}
+	if opts != nil && opts.LooseSearch {
+		red, blue, e = db.SearchFighters(redName, blueName)
+	} else {
+		red, blue, e = db.GetFighters(redName, blueName)
+	}
 
-			sprinkleMrsDash(data)
-		} else {
-			log("%v", e)
+	if e == nil {
+		p1f := formatFighterName(red, data.RedName, format("p1_name"))
+		p2f := formatFighterName(blue, data.BlueName, format("p2_name"))
+		p1stats := formatFighterStats(red)
+		p2stats := formatFighterStats(blue)
+		// ht := getHightowerUrl(data.RedName, data.BlueName)
+
+		var card string = ""
+		if len(data.RedName) > 0 && len(data.BlueName) > 0 {
+			card = fmt.Sprintf(format("vs"), p1f, p1stats, p2f, p2stats /*, ht*/)
+		} else if len(data.RedName) > 0 {
+			card = fmt.Sprintf(format("solo"), p1f, p1stats /*, ht*/)
+		} else if len(data.BlueName) > 0 {
+			card = fmt.Sprintf(format("solo"), p2f, p2stats /*, ht*/)
 		}
+
+		say(card)
+
+		state, err := db.GetRematchState(red, blue)
+		if err == nil {
+			switch state {
+			case storage.TradedWins:
+				say(fmt.Sprintf(format("rematch_trade"), p1f, p2f))
+			case storage.RedBeatBlue:
+				say(fmt.Sprintf(format("rematch"), p1f, p2f))
+			case storage.BlueBeatRed:
+				say(fmt.Sprintf(format("rematch"), p2f, p1f))
+			}
+		}
+
+		sprinkleMrsDash(data, say)
+	} else {
+		log("%v", e)
 	}
 }
 
-// sends odds to channel when available
+// sends odds to channels when available
 func announceOdds(data *spicerack.FightCard) {
-	p1 := formatFighterName(nil, data.RedName, P1_NAME_FORMAT)
-	p2 := formatFighterName(nil, data.BlueName, P2_NAME_FORMAT)
+	p1 := formatFighterName(nil, data.RedName, format("p1_name"))
+	p2 := formatFighterName(nil, data.BlueName, format("p2_name"))
 	msg := fmt.Sprintf("%s %s %s", p1, data.Odds(), p2)
-	client.Privmsg(settings.Channel, msg)
+	announce(ANNOUNCE_ODDS, msg)
 }
 
-// informs channel of the outcome of a match
+// informs channels of the outcome of a match
 func announceWinner(data *spicerack.FightCard) {
-	p1 := formatFighterName(nil, data.RedName, P1_NAME_FORMAT)
-	p2 := formatFighterName(nil, data.BlueName, P2_NAME_FORMAT)
+	p1 := formatFighterName(nil, data.RedName, format("p1_name"))
+	p2 := formatFighterName(nil, data.BlueName, format("p2_name"))
 	var w, l, msg string
 
 	if data.Winner() == data.RedName {
@@ -366,12 +913,12 @@ func announceWinner(data *spicerack.FightCard) {
 	}
 
 	if data.Upset(UPSET_FACTOR) {
-		msg = fmt.Sprintf(UPSET_WINNER_FORMAT, data.Odds(), w, l)
+		msg = fmt.Sprintf(format("upset_winner"), data.Odds(), w, l)
 	} else {
-		msg = fmt.Sprintf(WINNER_FORMAT, w, l)
+		msg = fmt.Sprintf(format("winner"), w, l)
 	}
 
-	client.Privmsg(settings.Channel, msg)
+	announce(ANNOUNCE_WINNERS, msg)
 }
 
 // Gives irc formatting to a fighter name, or a fallback name if the fighter isn't in the db.
@@ -388,12 +935,12 @@ func formatFighterStats(f *spicerack.Fighter) string {
 	if f != nil {
 		return f.IrcStats()
 	}
-	return UNKNOWN_FIGHTER
+	return format("unknown_fighter")
 }
 
 // generates a shortened url link to hightower for the given fighters
 func getHightowerUrl(left, right string) string {
-	apiUrl := fmt.Sprintf(GOOGL_FORMAT, settings.GoogleApiKey)
+	apiUrl := fmt.Sprintf(GOOGL_FORMAT, settings().GoogleApiKey)
 	payload, _ := json.Marshal(&map[string]string{"longUrl": fmt.Sprintf(HT_FORMAT, escapeName(left), escapeName(right))})
 	result := &map[string]string{}
 
@@ -422,13 +969,11 @@ func escapeName(name string) string {
 	return strings.Replace(result, "+", "%20", -1)
 }
 
-// listen on an http endpoint for external messages to relay to the channel
+// listen on an http endpoint for external messages to relay to the channels taking them
 func listenForRelays() {
-	host, _ := os.Hostname()
-	port := 4380
-	addr := fmt.Sprintf("%s:%d", host, port)
-	http.HandleFunc(MESSAGE_ENDPOINT, handler)
-	log("Listening for message relays at http://%s%s", addr, MESSAGE_ENDPOINT)
+	addr := relay.Addr()
+	http.HandleFunc(relay.ENDPOINT, handler)
+	log("Listening for message relays at http://%s%s", addr, relay.ENDPOINT)
 	if err := http.ListenAndServe(addr, nil); err != nil {
 		log("%v", err)
 	}
@@ -441,7 +986,7 @@ func handler(w http.ResponseWriter, r *http.Request) {
 		msg := r.FormValue("Message")
 		if len(msg) > 0 {
 			log("Message: %s", msg)
-			client.Privmsg(settings.Channel, msg)
+			announce(ANNOUNCE_RELAYS, msg)
 			h.Set("X-Success", "true")
 		} else {
 			h.Set("X-Success", "false")
@@ -452,15 +997,19 @@ func handler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// sends pushover notifications
+// sends pushover notifications, if there's a pushover section to send them with
 func notify(message string) {
+	if !settings().Pushover.Enabled() {
+		log("Pushover isn't configured, not sending notification: %s", message)
+		return
+	}
 	form := url.Values{
-		"user":    {settings.Pushover["user_key"].(string)},
-		"token":   {settings.Pushover["irc_token"].(string)},
+		"user":    {settings().Pushover.UserKey},
+		"token":   {settings().Pushover.IrcToken},
 		"message": {message},
 		"sound":   {"siren"}}
 
-	if resp, err := http.PostForm(settings.Pushover["url"].(string), form); err != nil {
+	if resp, err := http.PostForm(settings().Pushover.Url, form); err != nil {
 		log("Failed to send notification: %v", err)
 	} else {
 		defer resp.Body.Close()
@@ -468,15 +1017,15 @@ func notify(message string) {
 }
 
 // ...and along comes sexy Mrs. Dash
-func sprinkleMrsDash(data *spicerack.FightCard) {
+func sprinkleMrsDash(data *spicerack.FightCard, say func(msg string)) {
 	for _, x := range data.MrsDash {
 		switch x {
 		case "thats_my_boy":
-			client.Privmsg(settings.Channel, rainbowText("ALL IN ON MR. BONEGOLEM'S WILD RIDE"))
+			say(rainbowText("ALL IN ON MR. BONEGOLEM'S WILD RIDE"))
 		case "fake_astro":
-			client.Privmsg(settings.Channel, rainbowText("FAKE ASTRO, DON'T BET"))
+			say(rainbowText("FAKE ASTRO, DON'T BET"))
 		case "the_gawd":
-			client.Privmsg(settings.Channel, rainbowText("RESPEK THE GAWD, SON"))
+			say(rainbowText("RESPEK THE GAWD, SON"))
 		}
 	}
 }
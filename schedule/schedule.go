@@ -0,0 +1,118 @@
+/*
+When the scraper's daemon mode runs next: either every so often, or on a cron-like expression.
+*/
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// When to run next
+type Schedule interface {
+	Next(after time.Time) time.Time
+}
+
+// Every d, starting d from now
+type Interval struct {
+	Every time.Duration
+}
+
+// A cron-like minute/hour/day of month/month/day of week expression.
+// Each field is *, a number, a range (a-b), a list (a,b) or any of those with a /step.
+// As in cron, when both day fields are restricted a day matching either one will do.
+type Cron struct {
+	fields [5]map[int]bool
+	// whether the day of month & day of week fields start with *
+	anyDom, anyDow bool
+}
+
+// Builds a schedule from a cron expression if there is one, otherwise a plain interval
+func Parse(interval time.Duration, expr string) (Schedule, error) {
+	if expr == "" {
+		if interval <= 0 {
+			return nil, fmt.Errorf("interval must be positive, got %v", interval)
+		}
+		return &Interval{Every: interval}, nil
+	}
+
+	parts := strings.Fields(expr)
+	if len(parts) != 5 {
+		return nil, fmt.Errorf("expected 5 fields in %q, got %d", expr, len(parts))
+	}
+	bounds := [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 6}}
+	cs := &Cron{}
+	for i, part := range parts {
+		field, err := parseCronField(part, bounds[i][0], bounds[i][1])
+		if err != nil {
+			return nil, fmt.Errorf("field %d of %q: %v", i+1, expr, err)
+		}
+		cs.fields[i] = field
+	}
+	cs.anyDom, cs.anyDow = strings.HasPrefix(parts[2], "*"), strings.HasPrefix(parts[4], "*")
+	return cs, nil
+}
+
+func (s *Interval) Next(after time.Time) time.Time {
+	return after.Add(s.Every)
+}
+
+// Walks forward a minute at a time until everything matches; a year out is as far as it looks
+func (s *Cron) Next(after time.Time) time.Time {
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	for limit := t.AddDate(1, 0, 0); t.Before(limit); t = t.Add(time.Minute) {
+		if s.fields[0][t.Minute()] && s.fields[1][t.Hour()] && s.fields[3][int(t.Month())] && s.dayMatches(t) {
+			return t
+		}
+	}
+	return t
+}
+
+func (s *Cron) dayMatches(t time.Time) bool {
+	dom, dow := s.fields[2][t.Day()], s.fields[4][int(t.Weekday())]
+	if s.anyDom || s.anyDow {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// parses one comma separated cron field into the set of values it allows
+func parseCronField(field string, min, max int) (map[int]bool, error) {
+	values := make(map[int]bool)
+	for _, item := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(item, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(item[i+1:]); err != nil || step < 1 {
+				return nil, fmt.Errorf("bad step in %q", item)
+			}
+			item = item[:i]
+		}
+
+		lo, hi := min, max
+		if item != "*" {
+			bounds := strings.SplitN(item, "-", 2)
+			var err error
+			if lo, err = strconv.Atoi(bounds[0]); err != nil {
+				return nil, fmt.Errorf("bad value %q", item)
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				if hi, err = strconv.Atoi(bounds[1]); err != nil {
+					return nil, fmt.Errorf("bad range %q", item)
+				}
+			} else if step > 1 {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return nil, fmt.Errorf("%q is outside %d-%d", item, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			values[v] = true
+		}
+	}
+	return values, nil
+}
@@ -0,0 +1,80 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRejects(t *testing.T) {
+	tests := []struct {
+		interval time.Duration
+		expr     string
+	}{
+		{0, ""},
+		{-time.Minute, ""},
+		{0, "* * * *"},
+		{0, "* * * * * *"},
+		{0, "60 * * * *"},
+		{0, "* 24 * * *"},
+		{0, "* * 0 * *"},
+		{0, "* * * 13 *"},
+		{0, "* * * * 7"},
+		{0, "5-1 * * * *"},
+		{0, "*/0 * * * *"},
+		{0, "*/x * * * *"},
+		{0, "a * * * *"},
+		{0, "1-b * * * *"},
+	}
+	for _, tt := range tests {
+		if _, err := Parse(tt.interval, tt.expr); err == nil {
+			t.Errorf("Parse(%v, %q) = nil error, want one", tt.interval, tt.expr)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	// a Wednesday
+	from := time.Date(2014, time.January, 1, 10, 7, 30, 0, time.UTC)
+	at := func(month time.Month, day, hour, min int) time.Time {
+		return time.Date(2014, month, day, hour, min, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		interval time.Duration
+		expr     string
+		from     time.Time
+		want     time.Time
+	}{
+		{time.Hour, "", from, from.Add(time.Hour)},
+		{0, "* * * * *", from, at(time.January, 1, 10, 8)},
+		{0, "30 * * * *", from, at(time.January, 1, 10, 30)},
+		{0, "5 * * * *", from, at(time.January, 1, 11, 5)},
+		{0, "0 3 * * *", from, at(time.January, 2, 3, 0)},
+		{0, "*/15 * * * *", from, at(time.January, 1, 10, 15)},
+		{0, "*/15 * * * *", at(time.January, 1, 10, 45), at(time.January, 1, 11, 0)},
+		{0, "10/20 * * * *", from, at(time.January, 1, 10, 10)},
+		{0, "10/20 * * * *", at(time.January, 1, 10, 10), at(time.January, 1, 10, 30)},
+		{0, "0 9-17/4 * * *", from, at(time.January, 1, 13, 0)},
+		{0, "0,45 * * * *", from, at(time.January, 1, 10, 45)},
+		{0, "0 0 * 3 *", from, at(time.March, 1, 0, 0)},
+		// only mondays
+		{0, "0 3 * * 1", from, at(time.January, 6, 3, 0)},
+		// only the 1st
+		{0, "0 3 1 * *", from, at(time.February, 1, 3, 0)},
+		// either one: the following monday comes before the 1st
+		{0, "0 3 1 * 1", from, at(time.January, 6, 3, 0)},
+		// & the 1st comes before the following monday
+		{0, "0 3 1 * 1", at(time.January, 28, 3, 0), at(time.February, 1, 3, 0)},
+		// a * step still restricts, but counts as unrestricted for the day rule
+		{0, "0 3 */2 * 1", at(time.January, 6, 3, 0), at(time.January, 13, 3, 0)},
+	}
+	for _, tt := range tests {
+		s, err := Parse(tt.interval, tt.expr)
+		if err != nil {
+			t.Errorf("Parse(%v, %q): %v", tt.interval, tt.expr, err)
+			continue
+		}
+		if got := s.Next(tt.from); !got.Equal(tt.want) {
+			t.Errorf("Parse(%v, %q).Next(%v) = %v, want %v", tt.interval, tt.expr, tt.from, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+/*
+Passwords, tokens & keys for the config. A config value of "env:NAME" is read from the NAME
+environment variable, "file:/some/path" from a file only its owner can read; anything else is
+taken as is. Every secret resolved is remembered, so it can be scrubbed from anything printed.
+*/
+package secrets
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	ENV_PREFIX  string = "env:"
+	FILE_PREFIX string = "file:"
+	REDACTED    string = "[redacted]"
+	// shorter values turn up in ordinary output too often to scrub, "abc" would take words with it
+	MIN_REDACT_LEN int = 6
+)
+
+var (
+	mu    sync.RWMutex
+	known []string
+)
+
+// Resolves the secret config value for key, remembering the result for redaction.
+// Errors name the key & where it was looked for, never the value.
+func Resolve(key, value string) (string, error) {
+	var secret string
+	switch {
+	case strings.HasPrefix(value, ENV_PREFIX):
+		name := strings.TrimPrefix(value, ENV_PREFIX)
+		v := os.Getenv(name)
+		if v == "" {
+			return "", fmt.Errorf("%s: environment variable %s isn't set", key, name)
+		}
+		secret = v
+	case strings.HasPrefix(value, FILE_PREFIX):
+		v, err := readFile(strings.TrimPrefix(value, FILE_PREFIX))
+		if err != nil {
+			return "", fmt.Errorf("%s: %v", key, err)
+		}
+		secret = v
+	default:
+		secret = value
+	}
+	Remember(secret)
+	return secret, nil
+}
+
+// Resolves a set of secrets in place, keyed by config name. Every problem is reported, not just the first.
+func ResolveAll(values map[string]*string) error {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var problems []string
+	for _, key := range keys {
+		secret, err := Resolve(key, *values[key])
+		if err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+		*values[key] = secret
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("couldn't resolve secrets: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Whether a config value is a secret written straight into the config, rather than a reference to one
+func IsPlaintext(value string) bool {
+	return value != "" && !strings.HasPrefix(value, ENV_PREFIX) && !strings.HasPrefix(value, FILE_PREFIX)
+}
+
+// Adds a value to be scrubbed from output, unless it's too short to tell apart from anything else
+func Remember(secret string) {
+	if len(secret) < MIN_REDACT_LEN {
+		return
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for _, k := range known {
+		if k == secret {
+			return
+		}
+	}
+	// longest first, so a secret containing another is scrubbed whole
+	i := 0
+	for i < len(known) && len(known[i]) >= len(secret) {
+		i++
+	}
+	known = append(known, "")
+	copy(known[i+1:], known[i:])
+	known[i] = secret
+}
+
+// Replaces every known secret in s
+func Redact(s string) string {
+	mu.RLock()
+	defer mu.RUnlock()
+	for _, secret := range known {
+		s = strings.Replace(s, secret, REDACTED, -1)
+	}
+	return s
+}
+
+// Wraps w so anything written to it is redacted first
+func NewWriter(w io.Writer) io.Writer {
+	return &writer{w}
+}
+
+type writer struct {
+	w io.Writer
+}
+
+// Reports the whole of p as written, since the redacted text can be a different length
+func (r *writer) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(r.w, Redact(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Reads a secret file, refusing any that group or others can read
+func readFile(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if perm := info.Mode().Perm(); perm&0077 != 0 {
+		return "", fmt.Errorf("secret file %s can be read by others (mode %04o), chmod it to 0600", path, perm)
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
@@ -0,0 +1,144 @@
+package secrets
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Starts each test without anything remembered by the last
+func forgetAll(t *testing.T) {
+	mu.Lock()
+	known = nil
+	mu.Unlock()
+	t.Cleanup(func() {
+		mu.Lock()
+		known = nil
+		mu.Unlock()
+	})
+}
+
+func TestResolve(t *testing.T) {
+	forgetAll(t)
+	dir, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(name, contents string, perm os.FileMode) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(contents), perm); err != nil {
+			t.Fatal(err)
+		}
+		// WriteFile's perm is filtered through the umask
+		if err := os.Chmod(path, perm); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	os.Setenv("DREAMER_TEST_PASS", "hunter2-from-env")
+	defer os.Unsetenv("DREAMER_TEST_PASS")
+	os.Unsetenv("DREAMER_TEST_UNSET")
+
+	tests := []struct {
+		value, want string
+		err         string
+	}{
+		{"plain-pass", "plain-pass", ""},
+		{"", "", ""},
+		{"env:DREAMER_TEST_PASS", "hunter2-from-env", ""},
+		{"env:DREAMER_TEST_UNSET", "", "db_pass: environment variable DREAMER_TEST_UNSET isn't set"},
+		// trailing newlines from an editor or echo are dropped, nothing else is
+		{"file:" + write("owner-only", " file pass\n", 0600), " file pass", ""},
+		{"file:" + write("read-only", "read-only-pass\r\n", 0400), "read-only-pass", ""},
+		{"file:" + write("group", "group-pass", 0640), "", "can be read by others (mode 0640)"},
+		{"file:" + write("world", "world-pass", 0604), "", "can be read by others (mode 0604)"},
+		{"file:" + filepath.Join(dir, "missing"), "", "no such file"},
+	}
+	for _, tt := range tests {
+		got, err := Resolve("db_pass", tt.value)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("Resolve(%q) gave %q, %v, want an error about %q", tt.value, got, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("Resolve(%q) = %q, %v, want %q", tt.value, got, err, tt.want)
+		}
+	}
+
+	// what was resolved is scrubbed, what wasn't isn't
+	got := Redact("plain-pass hunter2-from-env read-only-pass group-pass")
+	if got != "[redacted] [redacted] [redacted] group-pass" {
+		t.Errorf("after resolving, redacted to %q", got)
+	}
+}
+
+func TestResolveAll(t *testing.T) {
+	forgetAll(t)
+	os.Unsetenv("DREAMER_TEST_UNSET")
+	pass, key, token := "plain-pass", "env:DREAMER_TEST_UNSET", "file:/nowhere/token"
+	err := ResolveAll(map[string]*string{"db_pass": &pass, "google_key": &key, "pushover_token": &token})
+	if err == nil {
+		t.Fatal("resolved secrets that aren't there")
+	}
+	// every problem, in key order
+	if msg := err.Error(); !strings.Contains(msg, "google_key: environment") || !strings.Contains(msg, "; pushover_token: ") ||
+		strings.Index(msg, "google_key") > strings.Index(msg, "pushover_token") {
+		t.Errorf("got %q", msg)
+	}
+	if pass != "plain-pass" || key != "env:DREAMER_TEST_UNSET" {
+		t.Errorf("values changed to %q & %q", pass, key)
+	}
+}
+
+func TestRedact(t *testing.T) {
+	forgetAll(t)
+	for _, secret := range []string{"abc", "", "12345", "letmein", "letmein2", "s3cr3t-t0ken", "letmein"} {
+		Remember(secret)
+	}
+
+	tests := map[string]string{
+		"nothing secret here": "nothing secret here",
+		"pass=letmein":        "pass=[redacted]",
+		"letmein letmein":     "[redacted] [redacted]",
+		"s3cr3t-t0ken@host":   "[redacted]@host",
+		// a longer secret containing a shorter one goes whole
+		"pass=letmein2": "pass=[redacted]",
+		// too short to be told apart from ordinary text, so left alone
+		"abc abcdef 12345 order #1234567": "abc abcdef 12345 order #1234567",
+	}
+	for in, want := range tests {
+		if got := Redact(in); got != want {
+			t.Errorf("Redact(%q) = %q, want %q", in, got, want)
+		}
+	}
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	n, err := w.Write([]byte("failed to log in as bot/letmein\n"))
+	if err != nil || n != len("failed to log in as bot/letmein\n") {
+		t.Errorf("Write reported %d, %v", n, err)
+	}
+	if buf.String() != "failed to log in as bot/[redacted]\n" {
+		t.Errorf("the writer passed on %q", buf.String())
+	}
+}
+
+func TestIsPlaintext(t *testing.T) {
+	for value, want := range map[string]bool{
+		"hunter2":        true,
+		"":               false,
+		"env:DB_PASS":    false,
+		"file:/etc/pass": false,
+		"environment":    true,
+	} {
+		if got := IsPlaintext(value); got != want {
+			t.Errorf("IsPlaintext(%q) = %v, want %v", value, got, want)
+		}
+	}
+}
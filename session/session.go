@@ -0,0 +1,305 @@
+/*
+A saltybet login shared by dreamer & the scraper. Keeps checking the session is still good,
+logs back in when it's expired, tells an expired login apart from a lapsed illuminati
+subscription, & says so (through the IRC bot) when either goes wrong.
+*/
+package session
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"spicerack"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// illuminati only; everyone else is turned away from it
+	CHECK_URL string = "http://www.saltybet.com/stats?tournamentstats=1"
+)
+
+// What salty shows in place of illuminati pages to anyone without a subscription, lower cased.
+// An empty table on its own just means there's nothing to show.
+var lapsedMarkers = []string{"become illuminati", "illuminati members only", "join the illuminati"}
+
+// Where salty sends anyone without a subscription, rather than showing them the page
+var lapsedPaths = []string{"illuminati", "shop"}
+
+type State int
+
+const (
+	Unknown State = iota
+	Active
+	LoggedOut
+	Lapsed
+	LoginFailed
+)
+
+func (s State) String() string {
+	switch s {
+	case Active:
+		return "active"
+	case LoggedOut:
+		return "logged out"
+	case Lapsed:
+		return "illuminati subscription lapsed"
+	case LoginFailed:
+		return "login failed"
+	}
+	return "unknown"
+}
+
+var (
+	ErrLapsed = errors.New("the illuminati subscription has lapsed, renew it to keep fight data coming")
+)
+
+// Hands out the one http.Client for a saltybet login. Logging back in swaps the cookies
+// underneath it, so anyone holding the client carries on with the new session.
+type Manager struct {
+	email, password string
+	// how long a good check is trusted before Client checks again
+	Interval time.Duration
+	// called when the session goes bad, & again when it recovers
+	Notify func(msg string)
+
+	mu      sync.Mutex
+	jar     *swappableJar
+	client  *http.Client
+	state   State
+	checked time.Time
+	lastErr error
+	// the check or login under way, if there is one
+	running *refresh
+	// where the session is checked & how a login is done; only ever swapped out by tests
+	checkUrl string
+	logIn    func(email, password string) (*http.Client, error)
+}
+
+// A check or login everyone who asks for one while it's under way waits on
+type refresh struct {
+	done  chan struct{}
+	state State
+	err   error
+}
+
+func NewManager(email, password string, timeout time.Duration) *Manager {
+	jar := &swappableJar{}
+	return &Manager{
+		email:    email,
+		password: password,
+		Interval: 10 * time.Minute,
+		jar:      jar,
+		client:   &http.Client{Jar: jar, Timeout: timeout},
+		checkUrl: CHECK_URL,
+		logIn:    spicerack.LogIntoSaltyBet,
+	}
+}
+
+// The logged in client, checking on the session first if it's been a while.
+// Errors once the login has failed or the subscription has lapsed.
+func (m *Manager) Client() (*http.Client, error) {
+	m.mu.Lock()
+	stale := time.Since(m.checked) >= m.Interval
+	m.mu.Unlock()
+
+	if stale {
+		m.Check()
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch m.state {
+	case Lapsed:
+		return m.client, ErrLapsed
+	case LoginFailed:
+		return m.client, fmt.Errorf("can't log into saltybet: %v", m.lastErr)
+	}
+	return m.client, nil
+}
+
+func (m *Manager) State() State {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.state
+}
+
+// Checks on the session right now, logging back in if it's expired
+func (m *Manager) Check() (State, error) {
+	return m.refresh(false)
+}
+
+// Logs in again whether or not the session looks expired, e.g. after a page bounced us to sign in.
+// Workers that all get bounced at once share a single login.
+func (m *Manager) Reauthenticate() error {
+	state, err := m.refresh(true)
+	if state == Lapsed {
+		return ErrLapsed
+	}
+	return err
+}
+
+// Checks (or with force, logs in & then checks) without holding the lock while salty's being
+// talked to. Only one runs at a time; anyone asking while it's under way gets its result.
+func (m *Manager) refresh(force bool) (State, error) {
+	m.mu.Lock()
+	if r := m.running; r != nil {
+		m.mu.Unlock()
+		<-r.done
+		return r.state, r.err
+	}
+	r := &refresh{done: make(chan struct{})}
+	m.running = r
+	m.mu.Unlock()
+
+	state, err := LoggedOut, error(nil)
+	if !force {
+		state, err = m.check()
+	}
+	if state == LoggedOut {
+		if err = m.login(); err != nil {
+			state = LoginFailed
+		} else {
+			state, err = m.check()
+		}
+	}
+
+	m.mu.Lock()
+	if state != Unknown {
+		// network trouble says nothing either way; keep what we knew
+		m.setState(state, err)
+	}
+	m.checked = time.Now()
+	r.state, r.err = m.state, err
+	m.running = nil
+	m.mu.Unlock()
+	close(r.done)
+	return r.state, r.err
+}
+
+// Checks on the session every interval until stop is closed, so problems are known about
+// (& announced) before anyone asks for a page
+func (m *Manager) Watch(every time.Duration, stop <-chan struct{}) {
+	m.Check()
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			m.Check()
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (m *Manager) login() error {
+	c, err := m.logIn(m.email, m.password)
+	if err != nil {
+		return err
+	}
+	if c == nil || c.Jar == nil {
+		return errors.New("logged in without a session cookie")
+	}
+	m.jar.swap(c.Jar)
+	return nil
+}
+
+// Fetches the illuminati only page to see where we stand; Unknown if it couldn't be fetched
+func (m *Manager) check() (State, error) {
+	if m.jar.empty() {
+		return LoggedOut, nil
+	}
+	resp, err := m.client.Get(m.checkUrl)
+	if err != nil {
+		return Unknown, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return LoggedOut, nil
+	case strings.Contains(resp.Request.URL.Path, "authenticate"):
+		// salty bounces logged out requests to the sign in page rather than a 401
+		return LoggedOut, nil
+	case containsAny(strings.ToLower(resp.Request.URL.Path), lapsedPaths):
+		return Lapsed, ErrLapsed
+	case resp.StatusCode != http.StatusOK:
+		return Unknown, fmt.Errorf("status %d checking the saltybet session", resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return Unknown, err
+	}
+	if containsAny(strings.ToLower(string(body)), lapsedMarkers) {
+		return Lapsed, ErrLapsed
+	}
+	return Active, nil
+}
+
+// Announces the session going bad, & coming good again after it had
+func (m *Manager) setState(state State, err error) {
+	old := m.state
+	m.state, m.lastErr = state, err
+	if state == old || m.Notify == nil {
+		return
+	}
+	switch state {
+	case Lapsed:
+		m.Notify("Saltybet's illuminati subscription has lapsed! Fight data will stop updating until it's renewed.")
+	case LoginFailed:
+		m.Notify(fmt.Sprintf("Can't log into saltybet (%v), fight data will stop updating.", err))
+	case Active:
+		if old == Lapsed || old == LoginFailed {
+			m.Notify("Saltybet session is back to normal.")
+		}
+	}
+}
+
+func containsAny(s string, subs []string) bool {
+	for _, sub := range subs {
+		if strings.Contains(s, sub) {
+			return true
+		}
+	}
+	return false
+}
+
+// A cookie jar whose cookies can be replaced wholesale while requests are using it
+type swappableJar struct {
+	mu  sync.RWMutex
+	jar http.CookieJar
+}
+
+func (j *swappableJar) swap(jar http.CookieJar) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.jar = jar
+}
+
+func (j *swappableJar) empty() bool {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	return j.jar == nil
+}
+
+func (j *swappableJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	if j.jar != nil {
+		j.jar.SetCookies(u, cookies)
+	}
+}
+
+func (j *swappableJar) Cookies(u *url.URL) []*http.Cookie {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	if j.jar == nil {
+		return nil
+	}
+	return j.jar.Cookies(u)
+}
@@ -0,0 +1,260 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Stands in for salty: hands out sessions on login, bounces expired ones to sign in & turns
+// away a lapsed subscription either by redirecting or with a wall in place of the page
+type fakeSalty struct {
+	mu       sync.Mutex
+	server   *httptest.Server
+	sessions map[string]bool
+	logins   int
+	checks   int
+	lapsed   string
+	loginErr error
+	// held shut to keep a login under way
+	gate chan struct{}
+}
+
+func newFakeSalty(t *testing.T) *fakeSalty {
+	s := &fakeSalty{sessions: make(map[string]bool)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.checks++
+		cookie, err := r.Cookie("session")
+		switch {
+		case err != nil || !s.sessions[cookie.Value]:
+			http.Redirect(w, r, "/authenticate?signin=1", http.StatusFound)
+		case s.lapsed == "redirect":
+			http.Redirect(w, r, "/shop/illuminati", http.StatusFound)
+		case s.lapsed == "wall":
+			fmt.Fprint(w, "<html><h1>Become Illuminati</h1><p>Illuminati members only.</p></html>")
+		default:
+			fmt.Fprint(w, "<html><table><tbody></tbody></table></html>")
+		}
+	})
+	mux.HandleFunc("/authenticate", func(w http.ResponseWriter, r *http.Request) { fmt.Fprint(w, "sign in") })
+	mux.HandleFunc("/shop/illuminati", func(w http.ResponseWriter, r *http.Request) { fmt.Fprint(w, "buy it") })
+	s.server = httptest.NewServer(mux)
+	t.Cleanup(s.server.Close)
+	return s
+}
+
+func (s *fakeSalty) logIn(email, password string) (*http.Client, error) {
+	if s.gate != nil {
+		<-s.gate
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.loginErr != nil {
+		return nil, s.loginErr
+	}
+	s.logins++
+	id := fmt.Sprintf("%s-%d", email, s.logins)
+	s.sessions[id] = true
+
+	jar, _ := cookiejar.New(nil)
+	u, _ := url.Parse(s.server.URL)
+	jar.SetCookies(u, []*http.Cookie{{Name: "session", Value: id, Path: "/"}})
+	return &http.Client{Jar: jar}, nil
+}
+
+// Forgets every session handed out, as salty does when one expires
+func (s *fakeSalty) expire() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions = make(map[string]bool)
+}
+
+func (s *fakeSalty) set(fn func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn()
+}
+
+func (s *fakeSalty) count() (logins, checks int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.logins, s.checks
+}
+
+func newTestManager(salty *fakeSalty) (*Manager, *[]string) {
+	m := NewManager("bot@example.com", "hunter22", time.Second)
+	m.checkUrl = salty.server.URL + "/stats?tournamentstats=1"
+	m.logIn = salty.logIn
+	var notified []string
+	var mu sync.Mutex
+	m.Notify = func(msg string) {
+		mu.Lock()
+		notified = append(notified, msg)
+		mu.Unlock()
+	}
+	return m, &notified
+}
+
+// Steps through a session's life, checking where it stands & what's been announced after each
+func TestManager(t *testing.T) {
+	salty := newFakeSalty(t)
+	m, notified := newTestManager(salty)
+
+	steps := []struct {
+		does    string
+		before  func()
+		state   State
+		logins  int
+		notices []string
+	}{
+		{"first use logs in", nil, Active, 1, nil},
+		{"an expired cookie logs back in quietly", salty.expire, Active, 2, nil},
+		{"a subscription redirect is a lapse", func() { salty.set(func() { salty.lapsed = "redirect" }) },
+			Lapsed, 2, []string{"illuminati subscription has lapsed"}},
+		{"still lapsed isn't announced again", nil, Lapsed, 2, nil},
+		{"renewing is announced", func() { salty.set(func() { salty.lapsed = "" }) },
+			Active, 2, []string{"back to normal"}},
+		{"a subscription wall is a lapse", func() { salty.set(func() { salty.lapsed = "wall" }) },
+			Lapsed, 2, []string{"illuminati subscription has lapsed"}},
+		{"an expired cookie while lapsed logs in & finds it still lapsed", salty.expire, Lapsed, 3, nil},
+		{"a login salty refuses", func() {
+			salty.set(func() { salty.lapsed, salty.loginErr = "", errors.New("bad password") })
+			salty.expire()
+		}, LoginFailed, 3, []string{"Can't log into saltybet (bad password)"}},
+		{"logging in again recovers", func() { salty.set(func() { salty.loginErr = nil }) },
+			Active, 4, []string{"back to normal"}},
+	}
+	for _, step := range steps {
+		*notified = nil
+		if step.before != nil {
+			step.before()
+		}
+		state, _ := m.Check()
+		if logins, _ := salty.count(); state != step.state || m.State() != step.state || logins != step.logins {
+			t.Fatalf("%s: %v after %d logins, want %v after %d", step.does, state, logins, step.state, step.logins)
+		}
+		if len(*notified) != len(step.notices) {
+			t.Fatalf("%s: announced %q, want %q", step.does, *notified, step.notices)
+		}
+		for i, want := range step.notices {
+			if !strings.Contains((*notified)[i], want) {
+				t.Errorf("%s: announced %q, want %q", step.does, (*notified)[i], want)
+			}
+		}
+	}
+}
+
+func TestClient(t *testing.T) {
+	salty := newFakeSalty(t)
+	m, _ := newTestManager(salty)
+	m.Interval = time.Hour
+
+	c, err := m.Client()
+	if err != nil || c == nil {
+		t.Fatalf("Client() = %v, %v", c, err)
+	}
+	// the client carries the session, & whatever it's swapped for
+	resp, err := c.Get(m.checkUrl)
+	if err != nil || resp.Request.URL.Path != "/stats" {
+		t.Fatalf("the client was bounced to %v, %v", resp.Request.URL, err)
+	}
+	resp.Body.Close()
+
+	// checked recently enough, so salty isn't asked again
+	salty.set(func() { salty.lapsed = "redirect" })
+	if _, err := m.Client(); err != nil {
+		t.Errorf("a fresh check was redone: %v", err)
+	}
+	if _, checks := salty.count(); checks != 2 {
+		t.Errorf("salty was checked %d times, want 2", checks)
+	}
+
+	m.Interval = 0
+	if _, err := m.Client(); err != ErrLapsed {
+		t.Errorf("Client() with a lapsed subscription gave %v, want ErrLapsed", err)
+	}
+	if err := m.Reauthenticate(); err != ErrLapsed {
+		t.Errorf("Reauthenticate() with a lapsed subscription gave %v, want ErrLapsed", err)
+	}
+
+	salty.set(func() { salty.lapsed, salty.loginErr = "", errors.New("bad password") })
+	salty.expire()
+	if _, err := m.Client(); err == nil || !strings.Contains(err.Error(), "bad password") {
+		t.Errorf("Client() after a failed login gave %v", err)
+	}
+}
+
+// Workers bounced to sign in all at once log in just the once between them
+func TestReauthenticateShared(t *testing.T) {
+	salty := newFakeSalty(t)
+	m, _ := newTestManager(salty)
+	if _, err := m.Check(); err != nil {
+		t.Fatal(err)
+	}
+	salty.expire()
+
+	salty.gate = make(chan struct{})
+	var started, done sync.WaitGroup
+	errs := make(chan error, 5)
+	for i := 0; i < 5; i++ {
+		started.Add(1)
+		done.Add(1)
+		go func() {
+			defer done.Done()
+			started.Done()
+			errs <- m.Reauthenticate()
+		}()
+	}
+	started.Wait()
+	time.Sleep(50 * time.Millisecond)
+	close(salty.gate)
+	done.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	if logins, _ := salty.count(); logins != 2 {
+		t.Errorf("%d logins, want the first & one shared one", logins)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	salty := newFakeSalty(t)
+	m, notified := newTestManager(salty)
+
+	stop := make(chan struct{})
+	stopped := make(chan bool)
+	go func() {
+		m.Watch(10*time.Millisecond, stop)
+		close(stopped)
+	}()
+
+	// a lapse is noticed without anyone asking for the client
+	salty.set(func() { salty.lapsed = "wall" })
+	deadline := time.Now().Add(2 * time.Second)
+	for m.State() != Lapsed && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	close(stop)
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Watch kept going after being stopped")
+	}
+	if m.State() != Lapsed || len(*notified) != 1 {
+		t.Errorf("watching ended %v having announced %q", m.State(), *notified)
+	}
+}
@@ -0,0 +1,219 @@
+package storage
+
+import (
+	"sort"
+	"spicerack"
+	"strings"
+	"sync"
+)
+
+// A throwaway store held in memory, for trying the site & bot out without a database.
+// It doesn't hand out transactions, so the scraper can't run against it, the bot can't merge
+// fighters & alias, compendium & roster lookups come back empty.
+type Memory struct {
+	mu       sync.RWMutex
+	fighters map[int]*spicerack.Fighter
+	matches  map[int]*spicerack.Match
+	nextId   int
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		fighters: make(map[int]*spicerack.Fighter),
+		matches:  make(map[int]*spicerack.Match),
+	}
+}
+
+// Callers get copies, so changes only stick once they're passed to UpdateFighter
+func (m *Memory) GetFighter(key interface{}) (*spicerack.Fighter, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	switch k := key.(type) {
+	case int:
+		for _, f := range m.fighters {
+			if f.CharacterId == k {
+				c := *f
+				return &c, nil
+			}
+		}
+	case string:
+		if f := m.byName(k); f != nil {
+			c := *f
+			return &c, nil
+		}
+	default:
+		return nil, ErrBadKey
+	}
+	return newFighter(key), nil
+}
+
+func (m *Memory) byName(name string) *spicerack.Fighter {
+	for _, f := range m.fighters {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+func (m *Memory) GetFighters(red, blue string) (*spicerack.Fighter, *spicerack.Fighter, error) {
+	r, err := m.GetFighter(red)
+	if err != nil {
+		return nil, nil, err
+	}
+	b, err := m.GetFighter(blue)
+	return r, b, err
+}
+
+// Case-insensitive substring match, preferring the shortest name
+func (m *Memory) SearchFighters(red, blue string) (*spicerack.Fighter, *spicerack.Fighter, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.search(red), m.search(blue), nil
+}
+
+func (m *Memory) search(name string) *spicerack.Fighter {
+	var best *spicerack.Fighter
+	needle := strings.ToLower(name)
+	for _, f := range m.fighters {
+		if strings.Contains(strings.ToLower(f.Name), needle) && (best == nil || len(f.Name) < len(best.Name)) {
+			best = f
+		}
+	}
+	if best == nil {
+		return newFighter(name)
+	}
+	c := *best
+	return &c
+}
+
+func (m *Memory) GetFighterNames() (map[int]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	names := make(map[int]string)
+	for _, f := range m.fighters {
+		names[f.CharacterId] = f.Name
+	}
+	return names, nil
+}
+
+func (m *Memory) GetUntieredCount() (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	count := 0
+	for _, f := range m.fighters {
+		if f.Tier == 0 {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// Fighters without an id are added & given one
+func (m *Memory) UpdateFighter(f *spicerack.Fighter) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if f.Id == 0 {
+		m.nextId++
+		f.Id = m.nextId
+		if f.Elo == 0 {
+			f.Elo = BASE_ELO
+		}
+	}
+	c := *f
+	m.fighters[f.Id] = &c
+	return nil
+}
+
+func (m *Memory) MatchExists(matchId int) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	_, ok := m.matches[matchId]
+	return ok
+}
+
+func (m *Memory) InsertMatch(match *spicerack.Match) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c := *match
+	m.matches[match.MatchId] = &c
+	return nil
+}
+
+func (m *Memory) GetHistory(f *spicerack.Fighter) *History {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	h := &History{Fighter: *f}
+	if f.Id == 0 {
+		return h
+	}
+	for _, id := range m.matchIds() {
+		match := m.matches[id]
+		var opponent *spicerack.Fighter
+		switch f.Id {
+		case match.RedId:
+			opponent = m.fighters[match.BlueId]
+		case match.BlueId:
+			opponent = m.fighters[match.RedId]
+		}
+		if opponent == nil {
+			continue
+		}
+		r := Record{Opponent: opponent.Name, Elo: opponent.Elo}
+		switch won(f.Id == match.RedId, match.Winner) {
+		case 1:
+			h.Wins = append(h.Wins, r)
+		case -1:
+			h.Losses = append(h.Losses, r)
+		}
+	}
+	return h
+}
+
+func (m *Memory) GetRematchState(red, blue *spicerack.Fighter) (RematchState, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if red.Id == 0 || blue.Id == 0 {
+		return NoRematch, nil
+	}
+	var redWins, blueWins int
+	for _, match := range m.matches {
+		if !(match.RedId == red.Id && match.BlueId == blue.Id) && !(match.RedId == blue.Id && match.BlueId == red.Id) {
+			continue
+		}
+		switch won(match.RedId == red.Id, match.Winner) {
+		case 1:
+			redWins++
+		case -1:
+			blueWins++
+		}
+	}
+	return rematchState(redWins, blueWins), nil
+}
+
+func (m *Memory) ResetElo(base int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, f := range m.fighters {
+		f.Elo = base
+	}
+}
+
+func (m *Memory) Close() {}
+
+// Match ids in the order they were played, so histories come out the same every time
+func (m *Memory) matchIds() []int {
+	ids := make([]int, 0, len(m.matches))
+	for id := range m.matches {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
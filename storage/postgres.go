@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"database/sql"
+	"spicerack"
+)
+
+// The production store, a thin layer over spicerack's repository
+type Postgres struct {
+	repo *spicerack.Repository
+}
+
+func OpenPostgres(user, pass, name string) (*Postgres, error) {
+	repo, err := spicerack.OpenDb(user, pass, name)
+	if err != nil {
+		return nil, err
+	}
+	return &Postgres{repo: repo}, nil
+}
+
+func (p *Postgres) GetFighter(key interface{}) (*spicerack.Fighter, error) {
+	return p.repo.GetFighter(key)
+}
+
+func (p *Postgres) GetFighters(red, blue string) (*spicerack.Fighter, *spicerack.Fighter, error) {
+	return p.repo.GetFighters(red, blue)
+}
+
+func (p *Postgres) SearchFighters(red, blue string) (*spicerack.Fighter, *spicerack.Fighter, error) {
+	return p.repo.SearchFighters(red, blue)
+}
+
+func (p *Postgres) GetFighterNames() (map[int]string, error) {
+	return p.repo.GetFighterNames()
+}
+
+func (p *Postgres) GetUntieredCount() (int, error) {
+	return p.repo.GetUntieredCount()
+}
+
+func (p *Postgres) UpdateFighter(f *spicerack.Fighter) error {
+	return p.repo.UpdateFighter(f)
+}
+
+func (p *Postgres) MatchExists(matchId int) bool {
+	return p.repo.MatchExists(matchId)
+}
+
+func (p *Postgres) InsertMatch(m *spicerack.Match) error {
+	return p.repo.InsertMatch(m)
+}
+
+func (p *Postgres) GetHistory(f *spicerack.Fighter) *History {
+	h := p.repo.GetHistory(f)
+	result := &History{Fighter: h.Fighter}
+	for _, w := range h.Wins {
+		result.Wins = append(result.Wins, Record{Opponent: w.Opponent, Elo: w.Elo})
+	}
+	for _, l := range h.Losses {
+		result.Losses = append(result.Losses, Record{Opponent: l.Opponent, Elo: l.Elo})
+	}
+	return result
+}
+
+func (p *Postgres) GetRematchState(red, blue *spicerack.Fighter) (RematchState, error) {
+	state, err := p.repo.GetRematchState(red, blue)
+	if err != nil {
+		return NoRematch, err
+	}
+	switch state {
+	case spicerack.TradedWins:
+		return TradedWins, nil
+	case spicerack.RedBeatBlue:
+		return RedBeatBlue, nil
+	case spicerack.BlueBeatRed:
+		return BlueBeatRed, nil
+	}
+	return NoRematch, nil
+}
+
+func (p *Postgres) ResetElo(base int) {
+	p.repo.ResetElo(base)
+}
+
+func (p *Postgres) Close() {
+	p.repo.Close()
+}
+
+func (p *Postgres) StartTransaction() (*sql.Tx, error) {
+	return p.repo.StartTransaction()
+}
+
+func (p *Postgres) UpdateFighterInTrans(f *spicerack.Fighter, tx *sql.Tx) error {
+	return p.repo.UpdateFighterInTrans(f, tx)
+}
+
+// spicerack only inserts matches on its own connection, so this writes the row itself to keep it
+// part of tx
+func (p *Postgres) InsertMatchInTrans(m *spicerack.Match, tx *sql.Tx) error {
+	_, err := tx.Exec(INSERT_MATCH_SQL,
+		m.MatchId, m.RedId, m.BlueId, m.RedBets, m.BlueBets, m.BetCount, m.Winner, m.Created, m.Updated)
+	return err
+}
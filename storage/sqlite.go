@@ -0,0 +1,338 @@
+package storage
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"github.com/mattn/go-sqlite3"
+	"regexp"
+	"spicerack"
+	"strings"
+)
+
+const (
+	SQLITE_DRIVER string = "dreamer_sqlite3"
+
+	CREATE_FIGHTERS_SQL string = `CREATE TABLE IF NOT EXISTS fighters (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		character_id integer NOT NULL DEFAULT 0,
+		name varchar(255) NOT NULL UNIQUE,
+		tier integer NOT NULL DEFAULT 0,
+		total_bets integer NOT NULL DEFAULT 0,
+		elo integer NOT NULL)`
+	CREATE_MATCHES_SQL string = `CREATE TABLE IF NOT EXISTS matches (
+		match_id integer PRIMARY KEY,
+		red_id integer NOT NULL REFERENCES fighters (id),
+		blue_id integer NOT NULL REFERENCES fighters (id),
+		red_bets integer NOT NULL,
+		blue_bets integer NOT NULL,
+		bet_count integer NOT NULL,
+		winner integer NOT NULL,
+		created timestamp NOT NULL,
+		updated timestamp NOT NULL)`
+
+	FIGHTER_COLUMNS string = `id, character_id, name, tier, total_bets, elo`
+)
+
+// The scraper & aliases write postgres-flavoured SQL; queries through this driver have their
+// $N placeholders & serial columns translated so the same statements run against SQLite.
+var (
+	placeholderRx = regexp.MustCompile(`\$(\d+)`)
+	serialRx      = regexp.MustCompile(`(?i)\bserial\s+PRIMARY\s+KEY\b`)
+)
+
+func init() {
+	sql.Register(SQLITE_DRIVER, &translatingDriver{&sqlite3.SQLiteDriver{}})
+}
+
+type translatingDriver struct {
+	driver.Driver
+}
+
+func (d *translatingDriver) Open(name string) (driver.Conn, error) {
+	conn, err := d.Driver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &translatingConn{conn}, nil
+}
+
+type translatingConn struct {
+	driver.Conn
+}
+
+func (c *translatingConn) Prepare(query string) (driver.Stmt, error) {
+	return c.Conn.Prepare(translate(query))
+}
+
+func translate(query string) string {
+	query = placeholderRx.ReplaceAllString(query, "?$1")
+	return serialRx.ReplaceAllString(query, "INTEGER PRIMARY KEY AUTOINCREMENT")
+}
+
+// A single-file store for running locally, no postgres required
+type SQLite struct {
+	db *sql.DB
+}
+
+func OpenSQLite(path string) (*SQLite, error) {
+	// sqlite allows one writer at a time; taking the write lock when a transaction starts &
+	// waiting on it keeps the scraper's workers from failing with "database is locked"
+	if !strings.Contains(path, "?") {
+		path += "?_busy_timeout=10000&_txlock=immediate"
+	}
+	db, err := sql.Open(SQLITE_DRIVER, path)
+	if err != nil {
+		return nil, err
+	}
+	for _, stmt := range []string{CREATE_FIGHTERS_SQL, CREATE_MATCHES_SQL} {
+		if _, err := db.Exec(stmt); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	return &SQLite{db: db}, nil
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanFighter(row scanner) (*spicerack.Fighter, error) {
+	f := &spicerack.Fighter{}
+	err := row.Scan(&f.Id, &f.CharacterId, &f.Name, &f.Tier, &f.TotalBets, &f.Elo)
+	return f, err
+}
+
+func (s *SQLite) GetFighter(key interface{}) (*spicerack.Fighter, error) {
+	var row *sql.Row
+	switch k := key.(type) {
+	case int:
+		row = s.db.QueryRow(`SELECT `+FIGHTER_COLUMNS+` FROM fighters WHERE character_id = $1`, k)
+	case string:
+		row = s.db.QueryRow(`SELECT `+FIGHTER_COLUMNS+` FROM fighters WHERE name = $1`, k)
+	default:
+		return nil, ErrBadKey
+	}
+	f, err := scanFighter(row)
+	if err == sql.ErrNoRows {
+		return newFighter(key), nil
+	}
+	return f, err
+}
+
+func (s *SQLite) GetFighters(red, blue string) (*spicerack.Fighter, *spicerack.Fighter, error) {
+	r, err := s.GetFighter(red)
+	if err != nil {
+		return nil, nil, err
+	}
+	b, err := s.GetFighter(blue)
+	return r, b, err
+}
+
+func (s *SQLite) SearchFighters(red, blue string) (*spicerack.Fighter, *spicerack.Fighter, error) {
+	r, err := s.searchFighter(red)
+	if err != nil {
+		return nil, nil, err
+	}
+	b, err := s.searchFighter(blue)
+	return r, b, err
+}
+
+func (s *SQLite) searchFighter(name string) (*spicerack.Fighter, error) {
+	row := s.db.QueryRow(`SELECT `+FIGHTER_COLUMNS+` FROM fighters WHERE name LIKE $1 ORDER BY length(name) LIMIT 1`,
+		"%"+name+"%")
+	f, err := scanFighter(row)
+	if err == sql.ErrNoRows {
+		return newFighter(name), nil
+	}
+	return f, err
+}
+
+func (s *SQLite) GetFighterNames() (map[int]string, error) {
+	rows, err := s.db.Query(`SELECT character_id, name FROM fighters`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := make(map[int]string)
+	for rows.Next() {
+		var id int
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return nil, err
+		}
+		names[id] = name
+	}
+	return names, rows.Err()
+}
+
+func (s *SQLite) GetUntieredCount() (count int, err error) {
+	err = s.db.QueryRow(`SELECT count(*) FROM fighters WHERE tier = 0`).Scan(&count)
+	return
+}
+
+func (s *SQLite) UpdateFighter(f *spicerack.Fighter) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err = s.UpdateFighterInTrans(f, tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Fighters without an id are inserted & given one
+func (s *SQLite) UpdateFighterInTrans(f *spicerack.Fighter, tx *sql.Tx) error {
+	if f.Id != 0 {
+		_, err := tx.Exec(`UPDATE fighters SET character_id = $2, name = $3, tier = $4, total_bets = $5, elo = $6 WHERE id = $1`,
+			f.Id, f.CharacterId, f.Name, f.Tier, f.TotalBets, f.Elo)
+		return err
+	}
+	if f.Elo == 0 {
+		f.Elo = BASE_ELO
+	}
+	res, err := tx.Exec(`INSERT INTO fighters (character_id, name, tier, total_bets, elo) VALUES ($1, $2, $3, $4, $5)`,
+		f.CharacterId, f.Name, f.Tier, f.TotalBets, f.Elo)
+	if err != nil {
+		return err
+	}
+	id, err := res.LastInsertId()
+	f.Id = int(id)
+	return err
+}
+
+func (s *SQLite) MatchExists(matchId int) bool {
+	var n int
+	s.db.QueryRow(`SELECT count(*) FROM matches WHERE match_id = $1`, matchId).Scan(&n)
+	return n > 0
+}
+
+func (s *SQLite) InsertMatch(m *spicerack.Match) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err = s.InsertMatchInTrans(m, tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLite) InsertMatchInTrans(m *spicerack.Match, tx *sql.Tx) error {
+	_, err := tx.Exec(INSERT_MATCH_SQL,
+		m.MatchId, m.RedId, m.BlueId, m.RedBets, m.BlueBets, m.BetCount, m.Winner, m.Created, m.Updated)
+	return err
+}
+
+func (s *SQLite) GetHistory(f *spicerack.Fighter) *History {
+	h := &History{Fighter: *f}
+	if f.Id == 0 {
+		return h
+	}
+	rows, err := s.db.Query(`SELECT m.red_id, m.winner, o.name, o.elo FROM matches m
+		JOIN fighters o ON o.id = CASE WHEN m.red_id = $1 THEN m.blue_id ELSE m.red_id END
+		WHERE m.red_id = $1 OR m.blue_id = $1`, f.Id)
+	if err != nil {
+		return h
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var redId, winner int
+		var r Record
+		if rows.Scan(&redId, &winner, &r.Opponent, &r.Elo) != nil {
+			continue
+		}
+		switch won(f.Id == redId, winner) {
+		case 1:
+			h.Wins = append(h.Wins, r)
+		case -1:
+			h.Losses = append(h.Losses, r)
+		}
+	}
+	return h
+}
+
+func (s *SQLite) GetRematchState(red, blue *spicerack.Fighter) (RematchState, error) {
+	if red.Id == 0 || blue.Id == 0 {
+		return NoRematch, nil
+	}
+	rows, err := s.db.Query(`SELECT red_id, winner FROM matches
+		WHERE (red_id = $1 AND blue_id = $2) OR (red_id = $2 AND blue_id = $1)`, red.Id, blue.Id)
+	if err != nil {
+		return NoRematch, err
+	}
+	defer rows.Close()
+
+	var redWins, blueWins int
+	for rows.Next() {
+		var redId, winner int
+		if err := rows.Scan(&redId, &winner); err != nil {
+			return NoRematch, err
+		}
+		switch won(red.Id == redId, winner) {
+		case 1:
+			redWins++
+		case -1:
+			blueWins++
+		}
+	}
+	return rematchState(redWins, blueWins), rows.Err()
+}
+
+func (s *SQLite) ResetElo(base int) {
+	s.db.Exec(`UPDATE fighters SET elo = $1`, base)
+}
+
+func (s *SQLite) Close() {
+	s.db.Close()
+}
+
+func (s *SQLite) StartTransaction() (*sql.Tx, error) {
+	return s.db.Begin()
+}
+
+// Unknown fighters; a character id or a name, but nothing else
+func newFighter(key interface{}) *spicerack.Fighter {
+	f := &spicerack.Fighter{Elo: BASE_ELO}
+	switch k := key.(type) {
+	case int:
+		f.CharacterId = k
+	case string:
+		f.Name = k
+	}
+	return f
+}
+
+// 1 if the fighter won the match, -1 if they lost & 0 if nobody did
+func won(wasRed bool, winner int) int {
+	switch spicerack.FightWinner(winner) {
+	case spicerack.WINNER_RED:
+		if wasRed {
+			return 1
+		}
+		return -1
+	case spicerack.WINNER_BLUE:
+		if wasRed {
+			return -1
+		}
+		return 1
+	}
+	return 0
+}
+
+func rematchState(redWins, blueWins int) RematchState {
+	switch {
+	case redWins > 0 && blueWins > 0:
+		return TradedWins
+	case redWins > 0:
+		return RedBeatBlue
+	case blueWins > 0:
+		return BlueBeatRed
+	}
+	return NoRematch
+}
@@ -0,0 +1,22 @@
+package storage
+
+import "testing"
+
+func TestTranslate(t *testing.T) {
+	tests := []struct{ query, want string }{
+		{`SELECT 1`, `SELECT 1`},
+		{`SELECT * FROM fighters WHERE id = $1`, `SELECT * FROM fighters WHERE id = ?1`},
+		{`UPDATE fighters SET name = $2 WHERE id = $1`, `UPDATE fighters SET name = ?2 WHERE id = ?1`},
+		{`SELECT * FROM matches WHERE p1 = $1 OR p2 = $1`, `SELECT * FROM matches WHERE p1 = ?1 OR p2 = ?1`},
+		{`INSERT INTO t VALUES ($9, $10, $11)`, `INSERT INTO t VALUES (?9, ?10, ?11)`},
+		{`CREATE TABLE t (id serial PRIMARY KEY, n integer)`, `CREATE TABLE t (id INTEGER PRIMARY KEY AUTOINCREMENT, n integer)`},
+		{"CREATE TABLE t (id SERIAL\n\t\tprimary key)", `CREATE TABLE t (id INTEGER PRIMARY KEY AUTOINCREMENT)`},
+		// only a serial primary key is rewritten
+		{`CREATE TABLE t (serial_no serial NOT NULL)`, `CREATE TABLE t (serial_no serial NOT NULL)`},
+	}
+	for _, tt := range tests {
+		if got := translate(tt.query); got != tt.want {
+			t.Errorf("translate(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+/*
+Storage backends for fighters, matches, ratings & history. Postgres (through spicerack) is what
+runs in production; SQLite & an in-memory store are there so everything can run locally.
+
+Only the Store methods work everywhere. The scraper, aliases, compendium & roster keep tables of
+their own & write postgres-flavoured SQL in SQLStore transactions, which SQLite runs translated
+& the memory store can't run at all; those need postgres or sqlite.
+*/
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"spicerack"
+)
+
+const (
+	// Starting rating for fighters the SQLite & memory stores haven't seen before
+	BASE_ELO int = 300
+
+	INSERT_MATCH_SQL string = `INSERT INTO matches (match_id, red_id, blue_id, red_bets, blue_bets, bet_count, winner, created, updated)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
+)
+
+// Whether two fighters have met before, and who won
+type RematchState int
+
+const (
+	NoRematch RematchState = iota
+	TradedWins
+	RedBeatBlue
+	BlueBeatRed
+)
+
+// A fighter's record: everyone they've beaten & lost to, with the opponent's rating
+type History struct {
+	Fighter spicerack.Fighter
+	Wins    []Record
+	Losses  []Record
+}
+
+type Record struct {
+	Opponent string
+	Elo      int
+}
+
+// The fighter, match, rating & history operations dreamer, the scraper & the bot need.
+// GetFighter takes either a name or a character id; unknown fighters come back with an Id of 0.
+type Store interface {
+	GetFighter(key interface{}) (*spicerack.Fighter, error)
+	GetFighters(red, blue string) (*spicerack.Fighter, *spicerack.Fighter, error)
+	SearchFighters(red, blue string) (*spicerack.Fighter, *spicerack.Fighter, error)
+	GetFighterNames() (map[int]string, error)
+	GetUntieredCount() (int, error)
+	UpdateFighter(f *spicerack.Fighter) error
+	MatchExists(matchId int) bool
+	InsertMatch(m *spicerack.Match) error
+	GetHistory(f *spicerack.Fighter) *History
+	GetRematchState(red, blue *spicerack.Fighter) (RematchState, error)
+	ResetElo(base int)
+	Close()
+}
+
+// Stores backed by a SQL database, which also hand out transactions. The scraper keeps its own
+// bookkeeping tables, so it needs one of these.
+type SQLStore interface {
+	Store
+	StartTransaction() (*sql.Tx, error)
+	UpdateFighterInTrans(f *spicerack.Fighter, tx *sql.Tx) error
+	InsertMatchInTrans(m *spicerack.Match, tx *sql.Tx) error
+}
+
+var (
+	ErrNotSQL = errors.New("this storage backend doesn't support SQL, use postgres or sqlite")
+	ErrBadKey = errors.New("fighters are looked up by name or character id")
+)
+
+// Opens a store by backend name: postgres (the default), sqlite (name is the database file)
+// or memory (everything else is ignored, & gone when the process exits)
+func Open(backend, user, pass, name string) (Store, error) {
+	switch backend {
+	case "", "postgres":
+		return OpenPostgres(user, pass, name)
+	case "sqlite":
+		return OpenSQLite(name)
+	case "memory":
+		return NewMemory(), nil
+	}
+	return nil, fmt.Errorf("unknown storage backend '%s'", backend)
+}
+
+// Like Open, but for callers that need SQL
+func OpenSQL(backend, user, pass, name string) (SQLStore, error) {
+	s, err := Open(backend, user, pass, name)
+	if err != nil {
+		return nil, err
+	}
+	if sqlStore, ok := s.(SQLStore); ok {
+		return sqlStore, nil
+	}
+	s.Close()
+	return nil, ErrNotSQL
+}
+
+// Runs fn in a transaction on a SQL store, committing if it succeeds & rolling back if it doesn't
+func WithTrans(db Store, fn func(tx *sql.Tx) error) error {
+	sqlStore, ok := db.(SQLStore)
+	if !ok {
+		return ErrNotSQL
+	}
+	tx, err := sqlStore.StartTransaction()
+	if err != nil {
+		return err
+	}
+	if err = fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,200 @@
+package storage
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"spicerack"
+	"testing"
+	"time"
+)
+
+// Runs test against a fresh memory store & a fresh SQLite file, so both behave the same way
+func eachStore(t *testing.T, test func(t *testing.T, s Store)) {
+	t.Run("memory", func(t *testing.T) {
+		test(t, NewMemory())
+	})
+	t.Run("sqlite", func(t *testing.T) {
+		s := openTestSQLite(t)
+		defer s.Close()
+		test(t, s)
+	})
+}
+
+func openTestSQLite(t *testing.T) *SQLite {
+	dir, err := ioutil.TempDir("", "storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := OpenSQLite(filepath.Join(dir, "salty.db"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	// not removed until the test's done with the store
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return s
+}
+
+func addFighter(t *testing.T, s Store, name string, elo int) *spicerack.Fighter {
+	f := &spicerack.Fighter{Name: name, Elo: elo}
+	if err := s.UpdateFighter(f); err != nil {
+		t.Fatalf("adding %s: %v", name, err)
+	}
+	return f
+}
+
+func addMatch(t *testing.T, s Store, id int, red, blue *spicerack.Fighter, winner spicerack.FightWinner) {
+	now := time.Now()
+	m := &spicerack.Match{MatchId: id, RedId: red.Id, BlueId: blue.Id, Winner: int(winner), Created: now, Updated: now}
+	if err := s.InsertMatch(m); err != nil {
+		t.Fatalf("adding match %d: %v", id, err)
+	}
+}
+
+func TestUpdateFighter(t *testing.T) {
+	eachStore(t, func(t *testing.T, s Store) {
+		f := &spicerack.Fighter{Name: "Ryu", CharacterId: 7}
+		if err := s.UpdateFighter(f); err != nil {
+			t.Fatal(err)
+		}
+		if f.Id == 0 || f.Elo != BASE_ELO {
+			t.Fatalf("new fighter got id %d & rating %d, want an id & %d", f.Id, f.Elo, BASE_ELO)
+		}
+
+		f.Elo, f.Tier = 350, 2
+		if err := s.UpdateFighter(f); err != nil {
+			t.Fatal(err)
+		}
+		for _, key := range []interface{}{"Ryu", 7} {
+			got, err := s.GetFighter(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *got != *f {
+				t.Errorf("GetFighter(%v) = %+v, want %+v", key, *got, *f)
+			}
+		}
+
+		unknown, err := s.GetFighter("Ken")
+		if err != nil || unknown.Id != 0 || unknown.Name != "Ken" || unknown.Elo != BASE_ELO {
+			t.Errorf("GetFighter of an unknown fighter = %+v, %v", *unknown, err)
+		}
+		if _, err := s.GetFighter(1.5); err != ErrBadKey {
+			t.Errorf("GetFighter(1.5) gave %v, want ErrBadKey", err)
+		}
+	})
+}
+
+func TestUpdateFighterInTrans(t *testing.T) {
+	s := openTestSQLite(t)
+	defer s.Close()
+
+	rolledBack := &spicerack.Fighter{Name: "Guile"}
+	tx, err := s.StartTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.UpdateFighterInTrans(rolledBack, tx); err != nil {
+		t.Fatal(err)
+	}
+	if rolledBack.Id == 0 {
+		t.Error("fighter wasn't given an id inside the transaction")
+	}
+	tx.Rollback()
+	if f, _ := s.GetFighter("Guile"); f.Id != 0 {
+		t.Errorf("rolled back fighter was kept with id %d", f.Id)
+	}
+
+	kept := addFighter(t, s, "Blanka", 0)
+	err = WithTrans(s, func(tx *sql.Tx) error {
+		kept.Elo = 410
+		return s.UpdateFighterInTrans(kept, tx)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f, _ := s.GetFighter("Blanka"); f.Elo != 410 {
+		t.Errorf("committed rating is %d, want 410", f.Elo)
+	}
+}
+
+// The memory store has no transactions; anything that needs them is turned away up front
+func TestMemoryIsNotSQL(t *testing.T) {
+	if _, err := OpenSQL("memory", "", "", ""); err != ErrNotSQL {
+		t.Errorf("OpenSQL(memory) gave %v, want ErrNotSQL", err)
+	}
+	called := false
+	err := WithTrans(NewMemory(), func(tx *sql.Tx) error {
+		called = true
+		return nil
+	})
+	if err != ErrNotSQL || called {
+		t.Errorf("WithTrans on memory gave %v & called fn: %v", err, called)
+	}
+}
+
+func TestGetHistory(t *testing.T) {
+	eachStore(t, func(t *testing.T, s Store) {
+		ryu := addFighter(t, s, "Ryu", 0)
+		ken := addFighter(t, s, "Ken", 320)
+		akuma := addFighter(t, s, "Akuma", 500)
+		addFighter(t, s, "Dan", 0)
+
+		addMatch(t, s, 1, ryu, ken, spicerack.WINNER_RED)
+		addMatch(t, s, 2, akuma, ryu, spicerack.WINNER_RED)
+		addMatch(t, s, 3, ken, ryu, spicerack.WINNER_BLUE)
+
+		h := s.GetHistory(ryu)
+		if h.Fighter != *ryu {
+			t.Errorf("history is for %+v, want %+v", h.Fighter, *ryu)
+		}
+		if len(h.Wins) != 2 || h.Wins[0] != (Record{"Ken", 320}) || h.Wins[1] != (Record{"Ken", 320}) {
+			t.Errorf("Ryu's wins = %v, want Ken twice", h.Wins)
+		}
+		if len(h.Losses) != 1 || h.Losses[0] != (Record{"Akuma", 500}) {
+			t.Errorf("Ryu's losses = %v, want Akuma", h.Losses)
+		}
+
+		if h := s.GetHistory(akuma); len(h.Wins) != 1 || len(h.Losses) != 0 {
+			t.Errorf("Akuma's history = %+v, want one win", *h)
+		}
+		dan, _ := s.GetFighter("Dan")
+		if h := s.GetHistory(dan); len(h.Wins)+len(h.Losses) != 0 {
+			t.Errorf("Dan never fought but has history %+v", *h)
+		}
+		if h := s.GetHistory(&spicerack.Fighter{Name: "Sakura"}); len(h.Wins)+len(h.Losses) != 0 {
+			t.Errorf("an unknown fighter has history %+v", *h)
+		}
+	})
+}
+
+func TestGetRematchState(t *testing.T) {
+	eachStore(t, func(t *testing.T, s Store) {
+		ryu := addFighter(t, s, "Ryu", 0)
+		ken := addFighter(t, s, "Ken", 0)
+		chun := addFighter(t, s, "Chun-Li", 0)
+		dan := addFighter(t, s, "Dan", 0)
+
+		// Ryu beats Ken from either side; Chun-Li & Ken trade wins; Dan's never fought
+		addMatch(t, s, 1, ryu, ken, spicerack.WINNER_RED)
+		addMatch(t, s, 2, ken, ryu, spicerack.WINNER_BLUE)
+		addMatch(t, s, 3, chun, ken, spicerack.WINNER_RED)
+		addMatch(t, s, 4, chun, ken, spicerack.WINNER_BLUE)
+
+		check := func(red, blue *spicerack.Fighter, want RematchState) {
+			got, err := s.GetRematchState(red, blue)
+			if err != nil || got != want {
+				t.Errorf("GetRematchState(%s, %s) = %v, %v, want %v", red.Name, blue.Name, got, err, want)
+			}
+		}
+		check(ryu, ken, RedBeatBlue)
+		check(ken, ryu, BlueBeatRed)
+		check(chun, ken, TradedWins)
+		check(ken, chun, TradedWins)
+		check(ryu, chun, NoRematch)
+		check(dan, ryu, NoRematch)
+		check(&spicerack.Fighter{Name: "Sakura"}, ryu, NoRematch)
+	})
+}